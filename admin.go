@@ -0,0 +1,215 @@
+package locker
+
+import (
+	htmltemplate "html/template"
+	"net/http"
+
+	"golang.org/x/net/context"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
+	"google.golang.org/appengine/taskqueue"
+	"google.golang.org/appengine/user"
+)
+
+const (
+	// ActionView shows the lock state of an entity
+	ActionView = "view"
+
+	// ActionRelease clears the lock so a pending task can aquire it
+	ActionRelease = "release"
+
+	// ActionRetry resets the retries and re-schedules the current sequence
+	ActionRetry = "retry"
+)
+
+// adminHTML is the template for the admin view of an entity
+const adminHTML = `<!DOCTYPE html>
+<html><head><title>Lock {{.Key}}</title></head><body>
+<h2>Lock {{.Key}}</h2>
+{{if .Message}}<p><b>{{.Message}}</b></p>{{end}}
+<table>
+<tr><th>Namespace</th><td>{{.Key.Namespace}}</td></tr>
+<tr><th>Status</th><td>{{.Lock.Status}} {{.Lock.Control}}</td></tr>
+<tr><th>Handler</th><td>{{.Lock.Path}}</td></tr>
+<tr><th>Sequence</th><td>{{.Lock.Sequence}}</td></tr>
+<tr><th>Retries</th><td>{{.Lock.Retries}}</td></tr>
+<tr><th>Holder</th><td>{{.Lock.RequestID}}</td></tr>
+<tr><th>Locked at</th><td>{{.Lock.Timestamp}}</td></tr>
+<tr><th>Last error</th><td>{{.Lock.LastError}}</td></tr>
+<tr><th>Error at</th><td>{{.Lock.ErrorTimestamp}} (sequence {{.Lock.ErrorSequence}})</td></tr>
+</table>
+{{with .DeadLetter}}<h3>Dead letter</h3>
+<table>
+<tr><th>Failed at</th><td>{{.Timestamp}}</td></tr>
+<tr><th>Path</th><td>{{.Path}}</td></tr>
+<tr><th>Params</th><td>{{.Params}}</td></tr>
+<tr><th>Sequence</th><td>{{.Sequence}}</td></tr>
+<tr><th>Error</th><td>{{.Error}}</td></tr>
+</table>{{end}}
+{{range .Actions}}<form method="POST" style="display:inline">
+<input type="hidden" name="key" value="{{$.EncodedKey}}">
+<button name="action" value="{{.}}">{{.}}</button>
+</form>{{end}}
+</body></html>`
+
+var adminTemplate = htmltemplate.Must(htmltemplate.New("admin").Parse(adminHTML))
+
+// AdminHandler returns an http.Handler for viewing and recovering locked
+// entities. GET requests show the lock state of the entity identified by
+// the encoded key parameter, POST requests perform the action parameter.
+// It should be restricted to admins in app.yaml:
+//
+//	handlers:
+//	- url: /_locker/admin
+//	  script: _go_app
+//	  login: admin
+//
+// Set AdminURL to the url it is mounted at to include links in alerts.
+func (l *Locker) AdminHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c := appengine.NewContext(r)
+		if !user.IsAdmin(c) {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		key, err := datastore.DecodeKey(r.FormValue("key"))
+		if err != nil {
+			http.Error(w, "invalid key", http.StatusBadRequest)
+			return
+		}
+
+		var message string
+		if r.Method == "POST" {
+			action := r.FormValue("action")
+			switch action {
+			case ActionRelease:
+				err = l.Release(c, key)
+			case ActionRetry:
+				err = l.Retry(c, key)
+			case ActionRedrive:
+				err = l.Redrive(c, key)
+			case ActionCancel:
+				err = l.Cancel(c, key)
+			case ActionPause:
+				err = l.Pause(c, key)
+			case ActionResume:
+				err = l.Resume(c, key)
+			default:
+				http.Error(w, "unknown action", http.StatusBadRequest)
+				return
+			}
+			if err != nil {
+				l.adminError(w, err)
+				return
+			}
+			message = action + " succeeded"
+		}
+
+		entity := new(RawEntity)
+		if err := datastore.Get(c, key, entity); err != nil {
+			l.adminError(w, err)
+			return
+		}
+
+		actions := []string{ActionRelease, ActionRetry}
+		dl, err := l.DeadLetter(c, key)
+		if err == nil {
+			actions = append(actions, ActionRedrive)
+		}
+		switch entity.Control {
+		case "":
+			actions = append(actions, ActionPause, ActionCancel)
+		case ControlPaused:
+			actions = append(actions, ActionResume, ActionCancel)
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		adminTemplate.Execute(w, map[string]interface{}{
+			"Key":        key,
+			"EncodedKey": key.Encode(),
+			"Lock":       entity.Lock,
+			"DeadLetter": dl,
+			"Message":    message,
+			"Actions":    actions,
+		})
+	})
+}
+
+// adminError writes the response for a failed admin request
+func (l *Locker) adminError(w http.ResponseWriter, err error) {
+	if err == datastore.ErrNoSuchEntity {
+		http.Error(w, err.Error(), http.StatusNotFound)
+	} else if lerr, ok := err.(Error); ok {
+		http.Error(w, lerr.Error(), lerr.Response)
+	} else {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// Release clears the lock on an entity so that a pending or retried task
+// for the current sequence can aquire it without waiting for the lease
+// to expire. The status is only changed if the entity is running, e.g. a
+// waiting or failed chain keeps its status.
+func (l *Locker) Release(c context.Context, key *datastore.Key) error {
+	return datastore.RunInTransaction(c, func(tc context.Context) error {
+		entity := new(RawEntity)
+		if err := datastore.Get(tc, key, entity); err != nil {
+			return err
+		}
+		entity.Timestamp = getTime()
+		entity.RequestID = ""
+		if entity.Status == StatusRunning {
+			entity.Status = StatusPending
+		}
+		if _, err := datastore.Put(tc, key, entity); err != nil {
+			return err
+		}
+		return l.record(tc, key, EventRelease, entity.Sequence, entity.Retries)
+	}, nil)
+}
+
+// Retry resets the retries of an entity and schedules a new task for the
+// current sequence on the queue it was scheduled on, e.g. to recover a
+// chain that failed permanently. The task only has params if they are stored on the lock (see PersistParams),
+// use Redrive to re-schedule the original task.
+func (l *Locker) Retry(c context.Context, key *datastore.Key) error {
+	return datastore.RunInTransaction(c, func(tc context.Context) error {
+		entity := new(RawEntity)
+		if err := datastore.Get(tc, key, entity); err != nil {
+			return err
+		}
+		if entity.Path == "" || entity.Sequence < 0 {
+			return ErrNotRetryable
+		}
+		entity.Timestamp = getTime()
+		entity.RequestID = ""
+		entity.Retries = 0
+		if !entity.Compensating {
+			entity.Status = StatusPending
+		}
+		if _, err := datastore.Put(tc, key, entity); err != nil {
+			return err
+		}
+		task := l.newTask(key, entity.Sequence, entity.Path, storedParams(&entity.Lock))
+		l.tracing().Inject(tc, task.Header)
+		if _, err := taskqueue.Add(tc, task, l.lockQueue(tc, &entity.Lock)); err != nil {
+			return err
+		}
+		// any dead-letter record is superseded by the new task
+		if err := datastore.Delete(tc, deadLetterKey(tc, key)); err != nil {
+			return err
+		}
+		return l.record(tc, key, EventSchedule, entity.Sequence, entity.Retries)
+	}, nil)
+}
+
+// Inspect returns the current lock state of an entity, including the
+// last error returned by its task handler
+func (l *Locker) Inspect(c context.Context, key *datastore.Key) (*Lock, error) {
+	entity := new(RawEntity)
+	if err := datastore.Get(c, key, entity); err != nil {
+		return nil, err
+	}
+	return &entity.Lock, nil
+}
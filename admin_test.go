@@ -0,0 +1,41 @@
+package locker
+
+import (
+	"testing"
+
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
+)
+
+func TestReleaseKeepsStatus(t *testing.T) {
+	r, _ := instance.NewRequest("GET", "/", nil)
+	c := appengine.NewContext(r)
+	k := scheduleFoo(t, c, 46, 1)
+
+	// a chain waiting on a timer isn't made pending
+	f := new(Foo)
+	datastore.Get(c, k, f)
+	f.Status = StatusWaiting
+	datastore.Put(c, k, f)
+
+	l, _ := NewLocker()
+	if err := l.Release(c, k); err != nil {
+		t.Fatal(err)
+	}
+	lock, _ := l.Inspect(c, k)
+	if lock.Status != StatusWaiting {
+		t.Errorf("expected status %s got %s", StatusWaiting, lock.Status)
+	}
+
+	// a running lock is released
+	f.Status = StatusRunning
+	f.RequestID = "abc"
+	datastore.Put(c, k, f)
+	if err := l.Release(c, k); err != nil {
+		t.Fatal(err)
+	}
+	lock, _ = l.Inspect(c, k)
+	if lock.Status != StatusPending || lock.RequestID != "" {
+		t.Errorf("expected released pending lock got %s %q", lock.Status, lock.RequestID)
+	}
+}
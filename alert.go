@@ -0,0 +1,141 @@
+package locker
+
+import (
+	"net/url"
+	"strconv"
+
+	"golang.org/x/net/context"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
+)
+
+// redacted replaces the value of redacted alert fields
+const redacted = "[redacted]"
+
+// AlertFields sets the config setting for a locker
+func AlertFields(names ...string) func(*Locker) error {
+	return func(l *Locker) error {
+		l.AlertFields = names
+		return nil
+	}
+}
+
+// AlertRedact sets the config setting for a locker
+func AlertRedact(names ...string) func(*Locker) error {
+	return func(l *Locker) error {
+		l.AlertRedact = names
+		return nil
+	}
+}
+
+// AdminURL sets the config setting for a locker
+func AdminURL(url string) func(*Locker) error {
+	return func(l *Locker) error {
+		l.AdminURL = url
+		return nil
+	}
+}
+
+// alert raises an alert about the entity using the configured notifier,
+// cause is the handler error that led to the alert (if any)
+func (l *Locker) alert(c context.Context, key *datastore.Key, entity Lockable, reason string, cause error) {
+	lock := entity.getLock()
+	alert := &Alert{
+		Reason:     reason,
+		Key:        key.String(),
+		EncodedKey: key.Encode(),
+		Kind:       key.Kind(),
+		Namespace:  key.Namespace(),
+		KeyPath:    keyPath(key),
+		Status:     lock.Status,
+		Sequence:   lock.Sequence,
+		Retries:    lock.Retries,
+		Holder:     lock.RequestID,
+		LockedAt:   lock.Timestamp,
+		Path:       lock.Path,
+		RequestID:  appengine.RequestID(c),
+		Timestamp:  getTime(),
+		Entity:     entity,
+		Fields:     l.alertFields(entity),
+		Links:      l.adminLinks(key),
+	}
+	if cause != nil {
+		alert.Error = cause.Error()
+	} else if lock.LastError != "" {
+		alert.Error = lock.LastError
+	}
+	if err := l.alerts().Notify(c, alert); err != nil {
+		l.log().Error(c, "failed to send alert for "+reason, withError(lockFields(c, key, lock.Sequence, lock.Retries), err)...)
+	}
+}
+
+// alertFields returns the entity properties to include in an alert.
+// Lock properties are excluded as they are reported separately.
+func (l *Locker) alertFields(entity Lockable) map[string]interface{} {
+	var props []datastore.Property
+	var err error
+	if pls, ok := entity.(datastore.PropertyLoadSaver); ok {
+		props, err = pls.Save()
+	} else {
+		props, err = datastore.SaveStruct(entity)
+	}
+	if err != nil {
+		return nil
+	}
+
+	allow := make(map[string]bool, len(l.AlertFields))
+	for _, name := range l.AlertFields {
+		allow[name] = true
+	}
+	redact := make(map[string]bool, len(l.AlertRedact))
+	for _, name := range l.AlertRedact {
+		redact[name] = true
+	}
+
+	fields := make(map[string]interface{})
+	for _, p := range props {
+		if lockProperties[p.Name] || len(allow) > 0 && !allow[p.Name] {
+			continue
+		}
+		var value interface{} = p.Value
+		if redact[p.Name] {
+			value = redacted
+		}
+		if p.Multiple {
+			values, _ := fields[p.Name].([]interface{})
+			value = append(values, value)
+		}
+		fields[p.Name] = value
+	}
+	return fields
+}
+
+// adminLinks returns the admin action urls for the entity
+func (l *Locker) adminLinks(key *datastore.Key) map[string]string {
+	if l.AdminURL == "" {
+		return nil
+	}
+	links := make(map[string]string)
+	for _, action := range []string{ActionView, ActionRelease, ActionRetry} {
+		v := url.Values{}
+		v.Set("key", key.Encode())
+		if action != ActionView {
+			v.Set("action", action)
+		}
+		links[action] = l.AdminURL + "?" + v.Encode()
+	}
+	return links
+}
+
+// keyPath decodes the key into its path elements, root ancestor first
+func keyPath(key *datastore.Key) []string {
+	var path []string
+	for k := key; k != nil; k = k.Parent() {
+		id := k.StringID()
+		if id == "" {
+			id = strconv.FormatInt(k.IntID(), 10)
+		}
+		path = append([]string{k.Kind() + "," + id}, path...)
+	}
+	return path
+}
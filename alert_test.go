@@ -0,0 +1,56 @@
+package locker
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
+)
+
+type Bar struct {
+	Lock
+	Name   string `datastore:"name"`
+	Secret string `datastore:"secret"`
+	Other  int    `datastore:"other"`
+}
+
+func TestAlertFields(t *testing.T) {
+	l, _ := NewLocker(AlertFields("name", "secret"), AlertRedact("secret"))
+	fields := l.alertFields(&Bar{Name: "bar", Secret: "hunter2", Other: 1})
+	if len(fields) != 2 {
+		t.Fatalf("expected 2 fields, got %v", fields)
+	}
+	if fields["name"] != "bar" || fields["secret"] != redacted {
+		t.Errorf("unexpected fields %v", fields)
+	}
+}
+
+func TestAlertTemplate(t *testing.T) {
+	r, _ := instance.NewRequest("GET", "/", nil)
+	c := appengine.NewContext(r)
+	parent := datastore.NewKey(c, "account", "acme", 0, nil)
+	k := datastore.NewKey(c, "foo", "", 1, parent)
+
+	l, _ := NewLocker(AdminURL("https://example.com/_locker/admin"))
+	alert := &Alert{
+		Reason:   ReasonFailure,
+		Key:      k.String(),
+		KeyPath:  keyPath(k),
+		Sequence: 2,
+		Error:    "partner api unavailable",
+		Links:    l.adminLinks(k),
+	}
+
+	buf := new(bytes.Buffer)
+	if err := DefaultTextTemplate.Execute(buf, alert); err != nil {
+		t.Fatal(err)
+	}
+	body := buf.String()
+	for _, want := range []string{"account,acme > foo,1", "Last error: partner api unavailable", "retry: https://example.com/_locker/admin?action=retry&key="} {
+		if !strings.Contains(body, want) {
+			t.Errorf("expected alert to contain %q\n%s", want, body)
+		}
+	}
+}
@@ -13,7 +13,7 @@ var (
 
 func TestMain(m *testing.M) {
 	var err error
-	instance, err = aetest.NewInstance(nil)
+	instance, err = aetest.NewInstance(&aetest.Options{StronglyConsistentDatastore: true})
 	if err != nil {
 		panic(err)
 	}
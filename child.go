@@ -0,0 +1,46 @@
+package locker
+
+import (
+	"net/http"
+
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
+)
+
+type (
+	// waitForChild is the TaskHandler result that starts a child chain and
+	// suspends the chain of the entity until it has completed or failed
+	waitForChild struct {
+		child *Child
+	}
+)
+
+// StartChild starts a chain on another entity and suspends the chain of
+// the entity until the child calls Locker.Complete or fails permanently,
+// the next task of the entity is then scheduled for the url path. If the
+// child failed then the Failed field of the lock will be 1.
+func (l *Locker) StartChild(c context.Context, key *datastore.Key, entity Lockable, path string, child *Child) error {
+	return l.FanOut(c, key, entity, path, []*Child{child})
+}
+
+// WaitForChild is returned by a TaskHandler to start a child chain and
+// suspend the entity until it completes or fails. The next task of the
+// entity is handled by the same url path as the current one.
+//
+//	case 1:
+//	  return locker.WaitForChild(&locker.Child{Key: k, Entity: e, Path: "/task/child"})
+//	case 2:
+//	  if foo.Failed > 0 {
+//	    ...
+//	  }
+func WaitForChild(child *Child) error {
+	return &waitForChild{child}
+}
+
+func (e *waitForChild) Error() string {
+	return "waiting for child " + e.child.Key.String()
+}
+
+func (e *waitForChild) suspend(c context.Context, l *Locker, r *http.Request, key *datastore.Key, entity Lockable) error {
+	return l.StartChild(c, key, entity, r.URL.Path, e.child)
+}
@@ -0,0 +1,48 @@
+package locker
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"golang.org/x/net/context"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
+)
+
+func TestWaitForChildFailure(t *testing.T) {
+	r, _ := instance.NewRequest("GET", "/", nil)
+	c := appengine.NewContext(r)
+	k := scheduleFoo(t, c, 33, 1)
+	ck := datastore.NewKey(c, "foo", "", 34, nil)
+
+	l, _ := NewLocker(MaxRetries(0))
+	h := l.Handle(func(c context.Context, r *http.Request, key *datastore.Key, entity Lockable) error {
+		if key.Equal(ck) {
+			return errors.New("child failed")
+		}
+		return WaitForChild(&Child{Key: ck, Entity: &Foo{Value: "child"}, Path: "/task/foo"})
+	}, fooFactory)
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, newTaskRequest(t, k, 1))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected parent to be suspended, got %d", rec.Code)
+	}
+	if lock, _ := l.Inspect(c, k); lock.Status != StatusWaiting || lock.Sequence != 2 {
+		t.Fatalf("expected parent to wait for child %#v", lock)
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, newTaskRequest(t, ck, 1))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected child to fail permanently, got %d", rec.Code)
+	}
+	joinChild(t, l, k, 2, ck, true)
+
+	lock, _ := l.Inspect(c, k)
+	if lock.Status != StatusPending || lock.Sequence != 2 || lock.Failed != 1 {
+		t.Errorf("expected parent to continue with failed child %#v", lock)
+	}
+}
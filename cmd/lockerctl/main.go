@@ -0,0 +1,146 @@
+// lockerctl is a command line tool for inspecting locked entities using
+// the remote API. The remote_api handler must be enabled for the app:
+//
+//	builtins:
+//	- remote_api: on
+//
+// Usage:
+//
+//	lockerctl -host myapp.appspot.com timeline [-format json|mermaid|dot] <key>
+//	lockerctl -host myapp.appspot.com replay [-force] <key> <sequence>
+//
+// where key is the URL-safe encoded datastore key of the entity.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+
+	"golang.org/x/net/context"
+	"golang.org/x/oauth2/google"
+	"google.golang.org/appengine/datastore"
+	"google.golang.org/appengine/remote_api"
+
+	"github.com/captaincodeman/datastore-locker"
+)
+
+type command func(c context.Context, l *locker.Locker, args []string) error
+
+var commands = map[string]command{
+	"timeline": timeline,
+	"replay":   replay,
+}
+
+func main() {
+	host := flag.String("host", "", "hostname of the app, e.g. myapp.appspot.com")
+	flag.Usage = usage
+	flag.Parse()
+
+	if *host == "" || flag.NArg() < 1 {
+		usage()
+		os.Exit(2)
+	}
+	cmd, ok := commands[flag.Arg(0)]
+	if !ok {
+		usage()
+		os.Exit(2)
+	}
+
+	c, err := remoteContext(*host)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "lockerctl: %v\n", err)
+		os.Exit(1)
+	}
+
+	l, _ := locker.NewLocker()
+	if err := cmd(c, l, flag.Args()[1:]); err != nil {
+		fmt.Fprintf(os.Stderr, "lockerctl: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: lockerctl -host <host> <command> [arguments]\n\n")
+	fmt.Fprintf(os.Stderr, "commands:\n")
+	fmt.Fprintf(os.Stderr, "  timeline [-format json|mermaid|dot] <key>\n")
+	fmt.Fprintf(os.Stderr, "  replay [-force] <key> <sequence>\n")
+}
+
+// remoteContext creates a context that makes API calls via the remote API
+func remoteContext(host string) (context.Context, error) {
+	const (
+		scopeCloud     = "https://www.googleapis.com/auth/cloud-platform"
+		scopeAppEngine = "https://www.googleapis.com/auth/appengine.apis"
+		scopeEmail     = "https://www.googleapis.com/auth/userinfo.email"
+	)
+	c := context.Background()
+	hc, err := google.DefaultClient(c, scopeCloud, scopeAppEngine, scopeEmail)
+	if err != nil {
+		return nil, err
+	}
+	client, err := remote_api.NewClient(host, hc)
+	if err != nil {
+		return nil, err
+	}
+	return client.NewContext(c), nil
+}
+
+func timeline(c context.Context, l *locker.Locker, args []string) error {
+	fs := flag.NewFlagSet("timeline", flag.ExitOnError)
+	format := fs.String("format", "json", "output format: json, mermaid or dot")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return fmt.Errorf("timeline requires a single key")
+	}
+
+	key, err := datastore.DecodeKey(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	t, err := l.Timeline(c, key)
+	if err != nil {
+		return err
+	}
+
+	switch *format {
+	case "json":
+		return t.WriteJSON(os.Stdout)
+	case "mermaid":
+		return t.WriteMermaid(os.Stdout)
+	case "dot":
+		return t.WriteDOT(os.Stdout)
+	}
+	return fmt.Errorf("unknown format %q", *format)
+}
+
+func replay(c context.Context, l *locker.Locker, args []string) error {
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	force := fs.Bool("force", false, "replay even if the lock is held")
+	fs.Parse(args)
+	if fs.NArg() != 2 {
+		return fmt.Errorf("replay requires a key and sequence")
+	}
+
+	key, err := datastore.DecodeKey(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	sequence, err := strconv.Atoi(fs.Arg(1))
+	if err != nil {
+		return err
+	}
+
+	entity := new(locker.RawEntity)
+	if *force {
+		err = l.ForceReplay(c, key, entity, sequence)
+	} else {
+		err = l.Replay(c, key, entity, sequence)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Printf("replaying %s from sequence %d\n", key, sequence)
+	return nil
+}
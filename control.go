@@ -0,0 +1,109 @@
+package locker
+
+import (
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
+	"google.golang.org/appengine/taskqueue"
+)
+
+const (
+	// ControlCancelled means the chain has been cancelled and will not
+	// run any more tasks
+	ControlCancelled = "cancelled"
+
+	// ControlPaused means the chain won't run any more tasks until it is
+	// resumed
+	ControlPaused = "paused"
+)
+
+const (
+	// ActionCancel cancels the chain
+	ActionCancel = "cancel"
+
+	// ActionPause pauses the chain
+	ActionPause = "pause"
+
+	// ActionResume resumes a paused chain
+	ActionResume = "resume"
+)
+
+// Cancel stops the chain of an entity, any task that runs for it will be
+// dropped with ErrTaskCancelled. A handler that is already running isn't
+// interrupted but the task it schedules won't run. Cancelling is final,
+// a cancelled entity can't be paused or resumed.
+func (l *Locker) Cancel(c context.Context, key *datastore.Key) error {
+	return l.control(c, key, ControlCancelled, EventCancel)
+}
+
+// Pause stops the chain of an entity until it is resumed, any task that
+// runs for it will be dropped with ErrTaskPaused
+func (l *Locker) Pause(c context.Context, key *datastore.Key) error {
+	return l.control(c, key, ControlPaused, EventPause)
+}
+
+// control sets the control state of an entity
+func (l *Locker) control(c context.Context, key *datastore.Key, control, eventType string) error {
+	return datastore.RunInTransaction(c, func(tc context.Context) error {
+		entity := new(RawEntity)
+		if err := datastore.Get(tc, key, entity); err != nil {
+			return err
+		}
+		if entity.Control == control {
+			return nil
+		}
+		if entity.Control == ControlCancelled {
+			return ErrTaskCancelled
+		}
+		entity.Control = control
+		if _, err := datastore.Put(tc, key, entity); err != nil {
+			return err
+		}
+		return l.record(tc, key, eventType, entity.Sequence, entity.Retries)
+	}, nil)
+}
+
+// Resume continues a paused chain. The task for the current sequence is
+// scheduled again if it could have been dropped while the chain was paused.
+// It returns ErrNotPaused if the chain isn't paused.
+func (l *Locker) Resume(c context.Context, key *datastore.Key) error {
+	return datastore.RunInTransaction(c, func(tc context.Context) error {
+		entity := new(RawEntity)
+		if err := datastore.Get(tc, key, entity); err != nil {
+			return err
+		}
+		if entity.Control != ControlPaused {
+			return ErrNotPaused
+		}
+		entity.Control = ""
+		if err := l.record(tc, key, EventResume, entity.Sequence, entity.Retries); err != nil {
+			return err
+		}
+
+		switch {
+		case (entity.Status == StatusPending || entity.Compensating) && entity.Path != "" && entity.Sequence > 0:
+			return l.resume(tc, key, entity)
+		case entity.Status == StatusWaiting && entity.Signal == "" && !entity.Wake.IsZero():
+			// re-arm the timer in case it fired while paused
+			task := l.newTask(key, entity.Sequence, entity.Path, storedParams(&entity.Lock))
+			task.ETA = entity.Wake
+			l.tracing().Inject(tc, task.Header)
+			if _, err := taskqueue.Add(tc, task, l.lockQueue(tc, &entity.Lock)); err != nil {
+				return err
+			}
+		}
+		_, err := datastore.Put(tc, key, entity)
+		return err
+	}, nil)
+}
+
+// keepControl copies the control state of the stored entity to the lock
+// so that a running handler doesn't overwrite a Cancel or Pause made since
+// it aquired the lock. It must be called in a transaction.
+func keepControl(c context.Context, key *datastore.Key, lock *Lock) error {
+	current := new(RawEntity)
+	if err := datastore.Get(c, key, current); err != nil && err != datastore.ErrNoSuchEntity {
+		return err
+	}
+	lock.Control = current.Control
+	return nil
+}
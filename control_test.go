@@ -0,0 +1,84 @@
+package locker
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"golang.org/x/net/context"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
+)
+
+func TestPauseResume(t *testing.T) {
+	r, _ := instance.NewRequest("GET", "/", nil)
+	c := appengine.NewContext(r)
+	k := scheduleFoo(t, c, 38, 1)
+
+	l, _ := NewLocker()
+	f := new(Foo)
+	if err := l.Aquire(c, k, f, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	// pausing while the handler is running stops the next task
+	if err := l.Pause(c, k); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Schedule(c, k, f, "/task/foo", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Aquire(c, k, new(Foo), 2); err != ErrTaskPaused {
+		t.Fatalf("expected task to be dropped while paused, got %v", err)
+	}
+
+	if err := l.Resume(c, k); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Resume(c, k); err != ErrNotPaused {
+		t.Errorf("expected resume of running chain to fail, got %v", err)
+	}
+	if err := l.Aquire(c, k, new(Foo), 2); err != nil {
+		t.Errorf("expected task to run after resume, got %v", err)
+	}
+}
+
+func TestCancel(t *testing.T) {
+	r, _ := instance.NewRequest("GET", "/", nil)
+	c := appengine.NewContext(r)
+	k := scheduleFoo(t, c, 39, 1)
+
+	l, _ := NewLocker()
+	if err := l.Cancel(c, k); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Aquire(c, k, new(Foo), 1); err != ErrTaskCancelled {
+		t.Errorf("expected task to be dropped, got %v", err)
+	}
+	if err := l.Pause(c, k); err != ErrTaskCancelled {
+		t.Errorf("expected cancelled chain not to be paused, got %v", err)
+	}
+	if err := l.Resume(c, k); err != ErrNotPaused {
+		t.Errorf("expected cancelled chain not to be resumed, got %v", err)
+	}
+}
+
+func TestCancelWhileSuspending(t *testing.T) {
+	r, _ := instance.NewRequest("GET", "/", nil)
+	c := appengine.NewContext(r)
+	k := scheduleFoo(t, c, 155, 1)
+
+	l, _ := NewLocker()
+	h := l.Handle(func(c context.Context, r *http.Request, key *datastore.Key, entity Lockable) error {
+		if err := l.Cancel(c, key); err != nil {
+			t.Fatal(err)
+		}
+		return WaitForSignal("approved")
+	}, fooFactory)
+	h.ServeHTTP(httptest.NewRecorder(), newTaskRequest(t, k, 1))
+
+	lock, _ := l.Inspect(c, k)
+	if lock.Control != ControlCancelled {
+		t.Errorf("expected suspending chain to stay cancelled %#v", lock)
+	}
+}
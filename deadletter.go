@@ -0,0 +1,140 @@
+package locker
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
+	"google.golang.org/appengine/taskqueue"
+)
+
+type (
+	// DeadLetter is the record of a task that failed permanently. It is
+	// stored as a child of the locked entity so that the same task can
+	// be re-scheduled with Redrive after the root cause is fixed.
+	DeadLetter struct {
+		// Key is the key of the locked entity
+		Key *datastore.Key `datastore:"key"`
+
+		// Timestamp is the time that the task failed
+		Timestamp time.Time `datastore:"ts"`
+
+		// Path is the url of the task handler
+		Path string `datastore:"path,noindex"`
+
+		// Queue is the name of the task queue the task ran on
+		Queue string `datastore:"queue,noindex"`
+
+		// Params is the url encoded form body of the task
+		Params string `datastore:"params,noindex"`
+
+		// Header is the task headers, each in "Name: value" form
+		Header []string `datastore:"header,noindex"`
+
+		// Sequence is the task sequence number that failed
+		Sequence int `datastore:"seq,noindex"`
+
+		// Error is the error that caused the failure
+		Error string `datastore:"err,noindex"`
+	}
+)
+
+// deadLetterKind is the datastore kind used for dead-letter records
+const deadLetterKind = "_lock_dead"
+
+// ActionRedrive re-schedules the task recorded in the dead-letter store
+const ActionRedrive = "redrive"
+
+// deadLetterKey returns the key of the dead-letter record for an entity,
+// there is only ever one as a chain stops at the first permanent failure
+func deadLetterKey(c context.Context, key *datastore.Key) *datastore.Key {
+	return datastore.NewKey(c, deadLetterKind, "", 1, key)
+}
+
+// newDeadLetter captures the failing task from the request
+func newDeadLetter(key *datastore.Key, r *http.Request, lock *Lock) *DeadLetter {
+	dl := &DeadLetter{
+		Key:       key,
+		Timestamp: getTime(),
+		Path:      r.URL.Path,
+		Queue:     r.Header.Get("X-Appengine-QueueName"),
+		Sequence:  lock.Sequence,
+		Error:     lock.LastError,
+	}
+	if err := r.ParseForm(); err == nil {
+		dl.Params = r.PostForm.Encode()
+	}
+	for name, values := range forwardHeaders(r.Header) {
+		for _, value := range values {
+			dl.Header = append(dl.Header, name+": "+value)
+		}
+	}
+	return dl
+}
+
+// DeadLetterQuery returns a query for all dead-letter records, most
+// recent first
+func DeadLetterQuery() *datastore.Query {
+	return datastore.NewQuery(deadLetterKind).Order("-ts")
+}
+
+// DeadLetter returns the dead-letter record for an entity
+func (l *Locker) DeadLetter(c context.Context, key *datastore.Key) (*DeadLetter, error) {
+	dl := new(DeadLetter)
+	if err := datastore.Get(c, deadLetterKey(c, key), dl); err != nil {
+		return nil, err
+	}
+	return dl, nil
+}
+
+// Redrive re-schedules the task recorded in the dead-letter store for an
+// entity. The retries are reset and the same sequence is scheduled with
+// the original path, params and headers on the original queue. The dead-letter record is deleted.
+func (l *Locker) Redrive(c context.Context, key *datastore.Key) error {
+	return datastore.RunInTransaction(c, func(tc context.Context) error {
+		dlKey := deadLetterKey(tc, key)
+		dl := new(DeadLetter)
+		if err := datastore.Get(tc, dlKey, dl); err != nil {
+			return err
+		}
+		entity := new(RawEntity)
+		if err := datastore.Get(tc, key, entity); err != nil {
+			return err
+		}
+		if entity.Sequence != dl.Sequence {
+			// the chain has moved on since the failure
+			return ErrNotRetryable
+		}
+
+		entity.Timestamp = getTime()
+		entity.RequestID = ""
+		entity.Retries = 0
+		entity.Status = StatusPending
+		if _, err := datastore.Put(tc, key, entity); err != nil {
+			return err
+		}
+
+		params, _ := url.ParseQuery(dl.Params)
+		task := l.newTask(key, dl.Sequence, dl.Path, params)
+		for _, h := range dl.Header {
+			parts := strings.SplitN(h, ": ", 2)
+			if len(parts) == 2 && task.Header.Get(parts[0]) == "" {
+				task.Header.Add(parts[0], parts[1])
+			}
+		}
+		queue := dl.Queue
+		if queue == "" {
+			queue = l.lockQueue(tc, &entity.Lock)
+		}
+		if _, err := taskqueue.Add(tc, task, queue); err != nil {
+			return err
+		}
+		if err := datastore.Delete(tc, dlKey); err != nil {
+			return err
+		}
+		return l.record(tc, key, EventSchedule, entity.Sequence, entity.Retries)
+	}, nil)
+}
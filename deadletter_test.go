@@ -0,0 +1,63 @@
+package locker
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
+)
+
+func TestDeadLetterRedrive(t *testing.T) {
+	r, _ := instance.NewRequest("POST", "/task/foo", strings.NewReader("a=1"))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.Header.Set("X-Lock-Seq", "2")
+	r.Header.Set("X-Custom", "value")
+	r.Header.Set("X-Appengine-QueueName", "default")
+	c := appengine.NewContext(r)
+
+	getTime = getTimeDefault
+	f := &Foo{
+		Value: "test",
+		Lock: Lock{
+			Timestamp: getTime(),
+			RequestID: "locked",
+			Sequence:  2,
+			Retries:   1,
+			Path:      "/task/foo",
+		},
+	}
+
+	k := datastore.NewKey(c, "foo", "", 4, nil)
+	if _, err := datastore.Put(c, k, f); err != nil {
+		t.Fatal(err)
+	}
+
+	l, _ := NewLocker(MaxRetries(1))
+	if err := l.clearLock(c, r, k, f, errors.New("invalid card")); err != ErrTaskFailed {
+		t.Fatalf("expected permanent failure, got %v", err)
+	}
+
+	dl, err := l.DeadLetter(c, k)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dl.Path != "/task/foo" || dl.Params != "a=1" || dl.Queue != "default" || dl.Sequence != 2 || dl.Error != "invalid card" {
+		t.Errorf("unexpected dead letter %#v", dl)
+	}
+	if !strings.Contains(strings.Join(dl.Header, "\n"), "X-Custom: value") {
+		t.Errorf("expected custom header to be captured %v", dl.Header)
+	}
+
+	if err := l.Redrive(c, k); err != nil {
+		t.Fatalf("redrive failed %v", err)
+	}
+	lock, _ := l.Inspect(c, k)
+	if lock.Retries != 0 || lock.Status != StatusPending || lock.Sequence != 2 {
+		t.Errorf("expected lock to be reset %#v", lock)
+	}
+	if _, err := l.DeadLetter(c, k); err != datastore.ErrNoSuchEntity {
+		t.Errorf("expected dead letter to be deleted, got %v", err)
+	}
+}
@@ -1,21 +1,68 @@
 package locker
 
 import (
-	"fmt"
+	"bytes"
+	htmltemplate "html/template"
+	texttemplate "text/template"
 
 	"golang.org/x/net/context"
 	"google.golang.org/appengine"
-	"google.golang.org/appengine/datastore"
 	"google.golang.org/appengine/mail"
 )
 
-func alertAdmins(c context.Context, key *datastore.Key, entity Lockable, reason string) error {
-	sender := "locker@" + appengine.AppID(c) + ".appspotmail.com"
+type (
+	// EmailNotifier sends alerts by email to the app admins
+	EmailNotifier struct {
+		// Sender is the sender address, it defaults to
+		// locker@[app-id].appspotmail.com
+		Sender string
+
+		// Text is the template for the plain text body, it defaults
+		// to DefaultTextTemplate. The template is executed with the
+		// *Alert as data.
+		Text *texttemplate.Template
+
+		// HTML is the template for the HTML body, it defaults to
+		// DefaultHTMLTemplate
+		HTML *htmltemplate.Template
+	}
+)
+
+// Notify implements Notifier
+func (n *EmailNotifier) Notify(c context.Context, alert *Alert) error {
+	sender := n.Sender
+	if sender == "" {
+		sender = "locker@" + appengine.AppID(c) + ".appspotmail.com"
+	}
+
+	text := n.Text
+	if text == nil {
+		text = DefaultTextTemplate
+	}
+	body := new(bytes.Buffer)
+	if err := text.Execute(body, alert); err != nil {
+		return err
+	}
+
+	html := n.HTML
+	if html == nil {
+		html = DefaultHTMLTemplate
+	}
+	htmlBody := new(bytes.Buffer)
+	if err := html.Execute(htmlBody, alert); err != nil {
+		return err
+	}
+
+	subject := alert.Reason
+	if alert.Key != "" {
+		subject += ": " + alert.Key
+	}
 
 	msg := &mail.Message{
-		Sender:  sender,
-		Subject: reason,
-		Body:    fmt.Sprintf("key: %s, entity: %#v", key.String(), entity),
+		Sender:   sender,
+		Subject:  subject,
+		Body:     body.String(),
+		HTMLBody: htmlBody.String(),
 	}
 
 	return mail.SendToAdmins(c, msg)
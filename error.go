@@ -1,7 +1,9 @@
 package locker
 
 import (
+	"fmt"
 	"net/http"
+	"time"
 )
 
 type (
@@ -11,6 +13,27 @@ type (
 		Response int
 		text     string
 	}
+
+	// permanentError wraps a handler error that will never succeed
+	permanentError struct {
+		err error
+	}
+
+	// PanicError is the error recorded when a task handler panics
+	PanicError struct {
+		// Value is the value passed to panic
+		Value interface{}
+
+		// Stack is the stack trace of the goroutine that panicked
+		Stack []byte
+	}
+
+	// retryAfterError wraps a handler error that should be retried after
+	// a delay
+	retryAfterError struct {
+		err   error
+		delay time.Duration
+	}
 )
 
 var (
@@ -29,8 +52,87 @@ var (
 	// the MaxRetries allowed) so should be abandoned.
 	// Using OK (200) causes a task to be marked as successful so it won't be retried.
 	ErrTaskFailed = Error{http.StatusOK, "task failed permanently (abandon)"}
+
+	// ErrTaskTimeout signals that the task handler failed after exceeding its
+	// deadline. The lock is released and the task retried.
+	ErrTaskTimeout = Error{http.StatusInternalServerError, "task exceeded deadline (retry)"}
+
+	// ErrNotRetryable signals that an entity can't be re-scheduled because
+	// the chain has completed or the task handler path isn't known.
+	ErrNotRetryable = Error{http.StatusConflict, "entity is not retryable"}
+
+	// ErrNotWaiting signals that a signal was delivered to an entity that
+	// isn't waiting for it
+	ErrNotWaiting = Error{http.StatusConflict, "entity is not waiting for the signal"}
+
+	// ErrSignalTimeout is the error recorded when a chain fails because
+	// it timed out waiting for a signal
+	ErrSignalTimeout = Error{http.StatusOK, "timed out waiting for signal (abandon)"}
+
+	// ErrTaskCancelled signals that the chain has been cancelled so the
+	// task should be dropped.
+	// Using OK (200) causes a task to be marked as successful so it won't be retried.
+	ErrTaskCancelled = Error{http.StatusOK, "chain cancelled (abandon)"}
+
+	// ErrTaskPaused signals that the chain has been paused so the task
+	// should be dropped, Resume will re-schedule it.
+	// Using OK (200) causes a task to be marked as successful so it won't be retried.
+	ErrTaskPaused = Error{http.StatusOK, "chain paused (abandon)"}
+
+	// ErrNotPaused signals that a chain can't be resumed because it isn't
+	// paused
+	ErrNotPaused = Error{http.StatusConflict, "entity is not paused"}
+
+	// ErrLockHeld signals that a chain can't be replayed because a task
+	// is currently holding the lock
+	ErrLockHeld = Error{http.StatusConflict, "lock is held by a running task"}
+
+	// ErrChainLimit signals that the chain has exceeded the MaxSequence or
+	// MaxChainDuration and has been failed.
+	// Using OK (200) causes a task to be marked as successful so it won't be retried.
+	ErrChainLimit = Error{http.StatusOK, "chain limit exceeded (abandon)"}
 )
 
 func (e Error) Error() string {
 	return e.text
 }
+
+// Permanent wraps an error returned by a TaskHandler to indicate that the
+// task will never succeed (e.g. a validation failure) so the chain should
+// be failed immediately instead of being retried until MaxRetries.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err}
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e *permanentError) Unwrap() error {
+	return e.err
+}
+
+// RetryAfter wraps an error returned by a TaskHandler to indicate that the
+// task should be retried after at least the delay (e.g. when a partner API
+// is rate limited). The retry still counts towards MaxRetries.
+func RetryAfter(err error, delay time.Duration) error {
+	if err == nil {
+		return nil
+	}
+	return &retryAfterError{err, delay}
+}
+
+func (e *retryAfterError) Error() string {
+	return e.err.Error()
+}
+
+func (e *retryAfterError) Unwrap() error {
+	return e.err
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("handler panic: %v", e.Value)
+}
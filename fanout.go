@@ -0,0 +1,377 @@
+package locker
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
+	"google.golang.org/appengine/taskqueue"
+)
+
+type (
+	// Child is a chain started by FanOut or StartChild on another entity.
+	// The child entity gets its own lock and is processed like any other
+	// chain, it must finish with Locker.Complete (or fail permanently) for
+	// the parent to continue.
+	Child struct {
+		// Key is the key of the child entity
+		Key *datastore.Key
+
+		// Entity is the child entity, it is written when the chain starts
+		Entity Lockable
+
+		// Path is the url of the task handler for the child
+		Path string
+
+		// Params are the params of the first child task
+		Params url.Values
+	}
+
+	// joinMarker records that a child chain has been counted against the
+	// fan out of its parent. It is stored in the entity group of the parent
+	// with the encoded child key as the name.
+	joinMarker struct {
+		Sequence int       `datastore:"seq,noindex"`
+		Started  time.Time `datastore:"started,noindex"`
+	}
+)
+
+// joinKind is the datastore kind used for join markers
+const joinKind = "_lock_join"
+
+const (
+	// fanOutHeader marks the task that seeds the children of a fan out
+	fanOutHeader = "X-Lock-FanOut"
+
+	// joinHeader marks the task that counts a child against its parent
+	joinHeader = "X-Lock-Join"
+
+	// putBatch is the maximum number of entities written in one call
+	putBatch = 500
+
+	// seedBatch is the maximum number of tasks added in one call
+	seedBatch = 100
+)
+
+// FanOut starts a chain for each of the children and suspends the chain
+// of the entity until they have all completed or failed, the next task
+// of the entity is then scheduled for the url path with the number of
+// failed children in the Failed field of the lock.
+//
+// The children are written first, then the entity is suspended within a
+// transaction that also schedules a task to the url path which seeds the
+// first task of each child in batches. The writes of the children and the
+// entity are not atomic but a child doesn't run until it is seeded. The
+// path must be handled by Handle or HandleWorkflow and the encoded child
+// keys must fit in a single task. The params of the first child task are
+// always stored on the child lock.
+//
+// A child that already has a chain in progress isn't overwritten, an error
+// is returned instead. If the next task of the entity would exceed the
+// chain limits the chain is failed and ErrChainLimit returned.
+//
+// Each child counts itself against the entity with a task to its own url
+// path when it completes or fails, so that contention on the entity delays
+// the count rather than using up the retries of the child.
+func (l *Locker) FanOut(c context.Context, key *datastore.Key, entity Lockable, path string, children []*Child) (err error) {
+	if len(children) == 0 {
+		return fmt.Errorf("locker: fan out without children")
+	}
+
+	c, end := l.tracing().Start(c, "locker.fanout")
+	defer func() { end(err) }()
+
+	if entity.getLock().nextExceeded() {
+		l.fail(c, nil, key, entity, ErrChainLimit)
+		return ErrChainLimit
+	}
+
+	lock := entity.getLock()
+	lock.next(path)
+	lock.Status = StatusWaiting
+	lock.Pending = len(children)
+	lock.Failed = 0
+
+	queue := l.queue(c)
+	lock.Queue = queue
+
+	keys := make([]*datastore.Key, len(children))
+	entities := make([]Lockable, len(children))
+	params := make(url.Values)
+	for i, child := range children {
+		if child.Key.Equal(key) {
+			return fmt.Errorf("locker: fan out child %s is the parent", child.Key)
+		}
+		clock := child.Entity.getLock()
+		clock.Sequence = 0
+		l.startChain(clock)
+		clock.next(child.Path)
+		clock.Params = child.Params.Encode()
+		clock.Parent = key
+		clock.ParentSequence = lock.Sequence
+		clock.Queue = queue
+		keys[i] = child.Key
+		entities[i] = child.Entity
+		params.Add("child", child.Key.Encode())
+	}
+
+	// a child doesn't run until it is seeded so it's safe to write them
+	// before the parent, they are overwritten if the fan out is retried
+	for i := 0; i < len(keys); i += putBatch {
+		j := i + putBatch
+		if j > len(keys) {
+			j = len(keys)
+		}
+		if err := l.checkChildren(c, key, lock.Sequence, keys[i:j]); err != nil {
+			return err
+		}
+		if _, err := datastore.PutMulti(c, keys[i:j], entities[i:j]); err != nil {
+			return err
+		}
+	}
+
+	task := l.newTask(key, lock.Sequence, path, params)
+	task.Header.Set(fanOutHeader, "1")
+	l.tracing().Inject(c, task.Header)
+
+	err = datastore.RunInTransaction(c, func(tc context.Context) error {
+		if err := keepControl(tc, key, lock); err != nil {
+			return err
+		}
+		if _, err := datastore.Put(tc, key, entity); err != nil {
+			return err
+		}
+		if _, err := taskqueue.Add(tc, task, queue); err != nil {
+			return err
+		}
+		return l.record(tc, key, EventFanOut, lock.Sequence, lock.Retries)
+	}, &datastore.TransactionOptions{Attempts: 3})
+
+	return err
+}
+
+// checkChildren returns an error if any of the child entities already has
+// a chain in progress, other than one written by an earlier attempt of the
+// same fan out that hasn't run yet
+func (l *Locker) checkChildren(c context.Context, key *datastore.Key, sequence int, keys []*datastore.Key) error {
+	existing := make([]*RawEntity, len(keys))
+	for i := range existing {
+		existing[i] = new(RawEntity)
+	}
+	err := datastore.GetMulti(c, keys, existing)
+	merr, _ := err.(appengine.MultiError)
+	if err != nil && merr == nil {
+		return err
+	}
+	for i, child := range existing {
+		if merr != nil && merr[i] != nil {
+			if merr[i] == datastore.ErrNoSuchEntity {
+				continue
+			}
+			return merr[i]
+		}
+		if child.ended() {
+			continue
+		}
+		retried := child.Parent != nil && child.Parent.Equal(key) && child.ParentSequence == sequence && child.Sequence == 1 && child.RequestID == ""
+		if !retried {
+			return fmt.Errorf("locker: fan out child %s already has a chain in progress", keys[i])
+		}
+	}
+	return nil
+}
+
+// seed schedules the first task of each child of a fan out of the entity
+// for the task request r. The tasks are named so that a seed that is
+// retried doesn't schedule a child twice.
+func (l *Locker) seed(c context.Context, r *http.Request, key *datastore.Key, sequence int) error {
+	parent := new(RawEntity)
+	if err := datastore.Get(c, key, parent); err != nil {
+		return err
+	}
+	// the parent has moved on, e.g. it was retried by an admin
+	if parent.Status != StatusWaiting || parent.Sequence != sequence {
+		return nil
+	}
+
+	if err := r.ParseForm(); err != nil {
+		return err
+	}
+	encoded := r.PostForm["child"]
+	queue := l.lockQueue(c, &parent.Lock)
+
+	for i := 0; i < len(encoded); i += seedBatch {
+		j := i + seedBatch
+		if j > len(encoded) {
+			j = len(encoded)
+		}
+		keys := make([]*datastore.Key, 0, j-i)
+		for _, s := range encoded[i:j] {
+			k, err := datastore.DecodeKey(s)
+			if err != nil {
+				return err
+			}
+			keys = append(keys, k)
+		}
+		children := make([]*RawEntity, len(keys))
+		for n := range children {
+			children[n] = new(RawEntity)
+		}
+		if err := datastore.GetMulti(c, keys, children); err != nil {
+			return err
+		}
+
+		var seeded []*datastore.Key
+		var tasks []*taskqueue.Task
+		for n, child := range children {
+			// the child has already run or been started again since
+			if child.Parent == nil || !child.Parent.Equal(key) || child.ParentSequence != sequence || child.Sequence != 1 {
+				continue
+			}
+			task := l.newTask(keys[n], child.Sequence, child.Path, storedParams(&child.Lock))
+			task.Name = seedTaskName(key, &parent.Lock, keys[n])
+			l.tracing().Inject(c, task.Header)
+			seeded = append(seeded, keys[n])
+			tasks = append(tasks, task)
+		}
+		if len(tasks) == 0 {
+			continue
+		}
+
+		added := make([]bool, len(tasks))
+		_, err := taskqueue.AddMulti(c, tasks, queue)
+		if merr, ok := err.(appengine.MultiError); ok {
+			for n, err := range merr {
+				if err != nil && err != taskqueue.ErrTaskAlreadyAdded {
+					return err
+				}
+				added[n] = err == nil
+			}
+		} else if err != nil {
+			return err
+		} else {
+			for n := range added {
+				added[n] = true
+			}
+		}
+		for n, k := range seeded {
+			if added[n] {
+				l.recordOutside(c, k, EventSchedule, 1, 0)
+			}
+		}
+	}
+	return nil
+}
+
+// seedTaskName returns the name of the first task of a child chain for
+// the fan out of the parent at its current sequence
+func seedTaskName(key *datastore.Key, parent *Lock, child *datastore.Key) string {
+	h := sha1.New()
+	fmt.Fprintf(h, "%s/%d/%d/%s", key.Encode(), parent.Sequence, parent.Started.UnixNano(), child.Encode())
+	return "lock-fanout-" + hex.EncodeToString(h.Sum(nil))
+}
+
+// joinKey returns the key of the join marker of a child chain
+func joinKey(c context.Context, parent, child *datastore.Key) *datastore.Key {
+	return datastore.NewKey(c, joinKind, child.Encode(), 0, parent)
+}
+
+// notifyParent schedules the task that counts the completion or failure
+// of the child chain of an entity against its parent. The task is sent to
+// the url path of the child and is handled by Handle without the lock, so
+// that contention on the parent doesn't count as a retry of the child. It
+// must be called in a transaction.
+func (l *Locker) notifyParent(c context.Context, key *datastore.Key, lock *Lock, failed bool) error {
+	params := url.Values{"child": {key.Encode()}}
+	if failed {
+		params.Set("failed", "1")
+	}
+	task := l.newTask(lock.Parent, lock.ParentSequence, lock.Path, params)
+	task.Header.Set(joinHeader, "1")
+	l.tracing().Inject(c, task.Header)
+	_, err := taskqueue.Add(c, task, l.lockQueue(c, lock))
+	return err
+}
+
+// joinTask handles the task scheduled by notifyParent for the parent entity
+func (l *Locker) joinTask(c context.Context, r *http.Request, key *datastore.Key, sequence int) error {
+	if err := r.ParseForm(); err != nil {
+		return err
+	}
+	child, err := datastore.DecodeKey(r.PostForm.Get("child"))
+	if err != nil {
+		return err
+	}
+	failed := r.PostForm.Get("failed") != ""
+	return datastore.RunInTransaction(c, func(tc context.Context) error {
+		return l.join(tc, key, sequence, child, failed)
+	}, nil)
+}
+
+// join counts the completion or failure of a child chain against the fan
+// out of its parent at the sequence and schedules the next task of the
+// parent when it is the last. It must be called in a transaction.
+func (l *Locker) join(c context.Context, key *datastore.Key, sequence int, child *datastore.Key, failed bool) error {
+	parent := new(RawEntity)
+	if err := datastore.Get(c, key, parent); err != nil {
+		return err
+	}
+	// the parent has moved on, e.g. it was retried by an admin
+	if parent.Status != StatusWaiting || parent.Sequence != sequence {
+		return nil
+	}
+
+	// a child that completes again, e.g. after it was redriven, mustn't be
+	// counted twice for the same fan out
+	jk := joinKey(c, key, child)
+	marker := new(joinMarker)
+	if err := datastore.Get(c, jk, marker); err != nil && err != datastore.ErrNoSuchEntity {
+		return err
+	}
+	if marker.Sequence == parent.Sequence && marker.Started.Equal(parent.Started) {
+		return nil
+	}
+	marker.Sequence = parent.Sequence
+	marker.Started = parent.Started
+	if _, err := datastore.Put(c, jk, marker); err != nil {
+		return err
+	}
+
+	parent.Pending--
+	if failed {
+		parent.Failed++
+	}
+	if err := l.record(c, key, EventJoin, parent.Sequence, parent.Retries); err != nil {
+		return err
+	}
+	if parent.Pending > 0 {
+		_, err := datastore.Put(c, key, parent)
+		return err
+	}
+	return l.resume(c, key, parent)
+}
+
+// resume schedules the task for the current sequence of a waiting entity
+// with any params stored on the lock. It must be called in a transaction.
+func (l *Locker) resume(c context.Context, key *datastore.Key, entity *RawEntity) error {
+	entity.Timestamp = getTime()
+	entity.RequestID = ""
+	if !entity.Compensating {
+		entity.Status = StatusPending
+	}
+	if _, err := datastore.Put(c, key, entity); err != nil {
+		return err
+	}
+	task := l.newTask(key, entity.Sequence, entity.Path, storedParams(&entity.Lock))
+	l.tracing().Inject(c, task.Header)
+	if _, err := taskqueue.Add(c, task, l.lockQueue(c, &entity.Lock)); err != nil {
+		return err
+	}
+	return l.record(c, key, EventSchedule, entity.Sequence, entity.Retries)
+}
@@ -0,0 +1,168 @@
+package locker
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/context"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
+)
+
+// joinChild runs the task that counts a completed or failed child against
+// the fan out of its parent
+func joinChild(t *testing.T, l *Locker, parent *datastore.Key, sequence int, child *datastore.Key, failed bool) {
+	params := url.Values{"child": {child.Encode()}}
+	if failed {
+		params.Set("failed", "1")
+	}
+	r, _ := instance.NewRequest("POST", "/task/child", strings.NewReader(params.Encode()))
+	r.Header = newTaskRequest(t, parent, sequence).Header
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.Header.Set(joinHeader, "1")
+	rec := httptest.NewRecorder()
+	l.Handle(nopStep, fooFactory).ServeHTTP(rec, r)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("join of child %s failed with %d", child, rec.Code)
+	}
+}
+
+func TestFanOutJoin(t *testing.T) {
+	r, _ := instance.NewRequest("GET", "/", nil)
+	c := appengine.NewContext(r)
+
+	l, _ := NewLocker()
+	k := scheduleFoo(t, c, 30, 1)
+	parent := new(Foo)
+	datastore.Get(c, k, parent)
+
+	children := []*Child{
+		{Key: datastore.NewKey(c, "foo", "", 31, nil), Entity: &Foo{Value: "a"}, Path: "/task/child"},
+		{Key: datastore.NewKey(c, "foo", "", 32, nil), Entity: &Foo{Value: "b"}, Path: "/task/child"},
+	}
+	if err := l.FanOut(c, k, parent, "/task/foo", children); err != nil {
+		t.Fatal(err)
+	}
+
+	lock, _ := l.Inspect(c, k)
+	if lock.Status != StatusWaiting || lock.Pending != 2 || lock.Sequence != 2 {
+		t.Fatalf("expected parent to wait for children %#v", lock)
+	}
+
+	for i, child := range children {
+		if err := l.Aquire(c, child.Key, child.Entity, 1); err != nil {
+			t.Fatal(err)
+		}
+		if err := l.Complete(c, child.Key, child.Entity); err != nil {
+			t.Fatal(err)
+		}
+		joinChild(t, l, k, 2, child.Key, false)
+		if i == 0 {
+			// a redriven child that completes again isn't counted twice
+			clock := child.Entity.getLock()
+			clock.Sequence = 1
+			clock.Status = StatusPending
+			clock.RequestID = ""
+			datastore.Put(c, child.Key, child.Entity)
+			if err := l.Aquire(c, child.Key, child.Entity, 1); err != nil {
+				t.Fatal(err)
+			}
+			if err := l.Complete(c, child.Key, child.Entity); err != nil {
+				t.Fatal(err)
+			}
+			joinChild(t, l, k, 2, child.Key, false)
+		}
+		lock, _ = l.Inspect(c, k)
+		if i == 0 && (lock.Status != StatusWaiting || lock.Pending != 1) {
+			t.Errorf("expected parent to wait for last child %#v", lock)
+		}
+	}
+
+	if lock.Status != StatusPending || lock.Pending != 0 || lock.Sequence != 2 {
+		t.Errorf("expected parent next sequence to be scheduled %#v", lock)
+	}
+
+	// completing a child again mustn't affect the parent
+	if err := l.Complete(c, children[0].Key, children[0].Entity); err != nil {
+		t.Fatal(err)
+	}
+	joinChild(t, l, k, 2, children[0].Key, false)
+	if lock, _ := l.Inspect(c, k); lock.Status != StatusPending || lock.Pending != 0 {
+		t.Errorf("expected repeat completion to be ignored %#v", lock)
+	}
+}
+
+func TestFanOutSeed(t *testing.T) {
+	r, _ := instance.NewRequest("GET", "/", nil)
+	c := appengine.NewContext(r)
+
+	l, _ := NewLocker()
+	k := scheduleFoo(t, c, 49, 1)
+	parent := new(Foo)
+	datastore.Get(c, k, parent)
+
+	var children []*Child
+	params := make(url.Values)
+	for id := int64(50); id < 50+seedBatch+5; id++ {
+		ck := datastore.NewKey(c, "foo", "", id, nil)
+		children = append(children, &Child{Key: ck, Entity: &Foo{Value: "child"}, Path: "/task/child", Params: url.Values{"n": {"1"}}})
+		params.Add("child", ck.Encode())
+	}
+	if err := l.FanOut(c, k, parent, "/task/foo", children); err != nil {
+		t.Fatal(err)
+	}
+
+	// seeding again doesn't fail on the tasks that were already added
+	h := l.Handle(func(c context.Context, r *http.Request, key *datastore.Key, entity Lockable) error {
+		t.Fatal("seed task must not run the handler")
+		return nil
+	}, fooFactory)
+	for i := 0; i < 2; i++ {
+		sr, _ := instance.NewRequest("POST", "/task/foo", strings.NewReader(params.Encode()))
+		sr.Header = newTaskRequest(t, k, 2).Header
+		sr.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		sr.Header.Set(fanOutHeader, "1")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, sr)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("seed %d failed with %d", i, rec.Code)
+		}
+	}
+
+	child := new(Foo)
+	datastore.Get(c, children[0].Key, child)
+	if child.Sequence != 1 || child.Params != "n=1" || !child.Parent.Equal(k) {
+		t.Errorf("expected child to be ready for its first task %#v", child.Lock)
+	}
+}
+
+func TestFanOutRunningChild(t *testing.T) {
+	r, _ := instance.NewRequest("GET", "/", nil)
+	c := appengine.NewContext(r)
+
+	l, _ := NewLocker()
+	k := scheduleFoo(t, c, 160, 1)
+	ck := scheduleFoo(t, c, 161, 1)
+	parent := new(Foo)
+	datastore.Get(c, k, parent)
+
+	err := l.FanOut(c, k, parent, "/task/foo", []*Child{{Key: ck, Entity: &Foo{Value: "child"}, Path: "/task/child"}})
+	if err == nil {
+		t.Fatal("expected fan out to a running chain to fail")
+	}
+	child := new(Foo)
+	datastore.Get(c, ck, child)
+	if child.Value != "test" || child.Parent != nil {
+		t.Errorf("expected running chain not to be overwritten %#v", child)
+	}
+}
+
+func TestFanOutNoChildren(t *testing.T) {
+	l, _ := NewLocker()
+	if err := l.FanOut(nil, nil, new(Foo), "/task/foo", nil); err == nil {
+		t.Error("expected fan out without children to fail")
+	}
+}
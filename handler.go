@@ -1,12 +1,15 @@
 package locker
 
 import (
+	"errors"
+	"fmt"
 	"net/http"
+	"runtime/debug"
+	"time"
 
 	"golang.org/x/net/context"
 	"google.golang.org/appengine"
 	"google.golang.org/appengine/datastore"
-	"google.golang.org/appengine/log"
 )
 
 type (
@@ -15,16 +18,46 @@ type (
 
 	// TaskHandler is the signature of the task handler
 	TaskHandler func(c context.Context, r *http.Request, key *datastore.Key, entity Lockable) error
+
+	// HandlerOption is the signature for per-handler configuration options
+	HandlerOption func(*handlerConfig)
+
+	// suspender is implemented by TaskHandler results that suspend the
+	// chain rather than fail it, e.g. WaitForChild
+	suspender interface {
+		suspend(c context.Context, l *Locker, r *http.Request, key *datastore.Key, entity Lockable) error
+	}
+
+	// handlerConfig is the configuration of a single handler
+	handlerConfig struct {
+		// timeout is the maximum time the handler is allowed to run
+		timeout time.Duration
+	}
 )
 
-// Handle wraps a task handler with task / lock processing
-func (l *Locker) Handle(handler TaskHandler, factory EntityFactory) http.Handler {
+// Timeout limits the time a handler may run for. The context passed to
+// the handler will never have a deadline later than the lease allows.
+func Timeout(timeout time.Duration) HandlerOption {
+	return func(hc *handlerConfig) {
+		hc.timeout = timeout
+	}
+}
+
+// Handle wraps a task handler with task / lock processing. The handler
+// context has a deadline computed from the LeaseTimeout (less the
+// DeadlineMargin) after which the lock could be overwritten.
+func (l *Locker) Handle(handler TaskHandler, factory EntityFactory, options ...HandlerOption) http.Handler {
+	config := new(handlerConfig)
+	for _, option := range options {
+		option(config)
+	}
+
 	fn := func(w http.ResponseWriter, r *http.Request) {
 		c := appengine.NewContext(r)
 
 		// ensure request is a task request
 		if r.Method != "POST" || r.Header.Get("X-Appengine-TaskName") == "" {
-			log.Warningf(c, "non task request")
+			l.log().Warning(c, "non task request", Field{FieldRequestID, appengine.RequestID(c)})
 			w.WriteHeader(http.StatusBadRequest)
 			return
 		}
@@ -33,17 +66,50 @@ func (l *Locker) Handle(handler TaskHandler, factory EntityFactory) http.Handler
 		queue := r.Header.Get("X-Appengine-QueueName")
 		c = WithQueue(c, queue)
 
+		// continue the trace from the task that scheduled this one
+		c = l.tracing().Extract(c, r.Header)
+
 		key, seq, err := l.Parse(c, r)
 		if err != nil {
-			log.Warningf(c, "parse failed: %v", err)
+			l.log().Warning(c, "parse failed", Field{FieldRequestID, appengine.RequestID(c)}, Field{FieldError, err.Error()})
 			w.WriteHeader(http.StatusBadRequest)
 			return
 		}
 
+		// the tasks that seed the children of a fan out and count them back
+		// against the parent don't need the lock
+		if r.Header.Get(fanOutHeader) != "" {
+			if err := l.seed(c, r, key, seq); err != nil {
+				l.log().Warning(c, "fan out seed failed", withError(lockFields(c, key, seq, 0), err)...)
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		if r.Header.Get(joinHeader) != "" {
+			if err := l.joinTask(c, r, key, seq); err != nil {
+				l.log().Warning(c, "fan out join failed", withError(lockFields(c, key, seq, 0), err)...)
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+
 		entity := factory()
-		err = l.Aquire(c, key, entity, seq)
+		ac, end := l.tracing().Start(c, "locker.acquire")
+		err = l.Aquire(ac, key, entity, seq)
+		end(err)
+		if err == ErrLockFailed && l.Backoff != nil {
+			if rerr := l.retryContention(c, r, key, seq); rerr == nil {
+				l.log().Debug(c, "lock failed, retry scheduled", lockFields(c, key, seq, entity.getLock().Retries)...)
+				w.WriteHeader(http.StatusOK)
+				return
+			}
+		}
 		if err != nil {
-			log.Warningf(c, "lock failed: %v", err)
+			l.log().Warning(c, "lock failed", withError(lockFields(c, key, seq, entity.getLock().Retries), err)...)
 			// if we have a lock error, it provides the http response to use
 			if lerr, ok := err.(Error); ok {
 				w.WriteHeader(lerr.Response)
@@ -53,23 +119,39 @@ func (l *Locker) Handle(handler TaskHandler, factory EntityFactory) http.Handler
 			return
 		}
 
+		// a task re-scheduled from the entity alone gets the stored params
+		if entity.getLock().Params != "" {
+			restoreParams(r, entity.getLock())
+		}
+
 		// TODO: explore having handler return something to indicate
 		// if the task needs to continue with the next seq or be completed
-		err = handler(c, r, key, entity)
+		held := time.Now()
+		hc, end := l.tracing().Start(c, "locker.handler")
+		hc, cancel := context.WithDeadline(hc, l.deadline(entity.getLock(), config))
+		err = l.callHandler(hc, handler, r, key, entity)
+		if err != nil && hc.Err() == context.DeadlineExceeded {
+			err = fmt.Errorf("%w: %w", ErrTaskTimeout, err)
+		}
+		cancel()
+		end(err)
+		l.metrics().Hold(c, key.Kind(), queue, time.Since(held))
+		var susp suspender
+		if errors.As(err, &susp) {
+			if err = susp.suspend(c, l, r, key, entity); err == nil {
+				l.log().Debug(c, "chain suspended", lockFields(c, key, seq, entity.getLock().Retries)...)
+				w.WriteHeader(http.StatusOK)
+				return
+			}
+		}
 		if err != nil {
-			log.Warningf(c, "handler failed: %v", err)
-			// clear the lock to allow the next retry
-			if err := l.clearLock(c, key, entity); err != nil {
-				log.Warningf(c, "clearLock failed: %v", err)
-				// if we have a lock error, it provides the http response to use
-				if lerr, ok := err.(Error); ok {
-					w.WriteHeader(lerr.Response)
-				} else {
-					w.WriteHeader(http.StatusInternalServerError)
-				}
-			} else {
-				w.WriteHeader(http.StatusInternalServerError)
+			l.log().Warning(c, "handler failed", withError(lockFields(c, key, seq, entity.getLock().Retries), err)...)
+			status := l.handlerFailed(c, r, key, entity, err)
+			var perr *PanicError
+			if errors.As(err, &perr) && l.RePanic {
+				panic(perr.Value)
 			}
+			w.WriteHeader(status)
 			return
 		}
 
@@ -78,3 +160,71 @@ func (l *Locker) Handle(handler TaskHandler, factory EntityFactory) http.Handler
 
 	return http.HandlerFunc(fn)
 }
+
+// deadline returns the time the handler must finish by. This is before the
+// lease timeout so that the lock can't be overwritten while it's running.
+func (l *Locker) deadline(lock *Lock, config *handlerConfig) time.Time {
+	deadline := lock.Timestamp.Add(l.LeaseTimeout - l.DeadlineMargin)
+	if config.timeout > 0 {
+		if d := time.Now().Add(config.timeout); d.Before(deadline) {
+			deadline = d
+		}
+	}
+	return deadline
+}
+
+// callHandler runs the task handler, converting a panic into a *PanicError
+// so that the lock can be released and the failure counted as a retry
+func (l *Locker) callHandler(c context.Context, handler TaskHandler, r *http.Request, key *datastore.Key, entity Lockable) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			perr := &PanicError{Value: p, Stack: debug.Stack()}
+			lock := entity.getLock()
+			l.log().Error(c, "handler panic", append(lockFields(c, key, lock.Sequence, lock.Retries),
+				Field{"panic", fmt.Sprint(p)}, Field{"stack", string(perr.Stack)})...)
+			err = perr
+		}
+	}()
+	return handler(c, r, key, entity)
+}
+
+// handlerFailed releases the lock after the task handler returned an error
+// and returns the http response to use
+func (l *Locker) handlerFailed(c context.Context, r *http.Request, key *datastore.Key, entity Lockable, err error) int {
+	var perr *permanentError
+	var rerr *retryAfterError
+	var cerr error
+
+	switch {
+	case errors.Is(err, ErrChainLimit):
+		// the chain has already been failed when it was scheduled
+		return http.StatusOK
+	case errors.As(err, &perr):
+		// no point retrying, fail the chain now
+		cerr = l.fail(c, r, key, entity, err)
+	case errors.As(err, &rerr):
+		// clear the lock and schedule the retry ourselves so the task
+		// can be marked as successful
+		if cerr = l.deferLock(c, r, key, entity, err, rerr.delay); cerr == nil {
+			return http.StatusOK
+		}
+	case l.Backoff != nil:
+		// clear the lock and schedule the retry with the backoff delay
+		delay := l.Backoff(entity.getLock().Retries + 1)
+		if cerr = l.deferLock(c, r, key, entity, err, delay); cerr == nil {
+			return http.StatusOK
+		}
+	default:
+		// clear the lock to allow the next retry
+		if cerr = l.clearLock(c, r, key, entity, err); cerr == nil {
+			return http.StatusInternalServerError
+		}
+	}
+
+	l.log().Warning(c, "clearLock failed", withError(lockFields(c, key, entity.getLock().Sequence, entity.getLock().Retries), cerr)...)
+	// if we have a lock error, it provides the http response to use
+	if lerr, ok := cerr.(Error); ok {
+		return lerr.Response
+	}
+	return http.StatusInternalServerError
+}
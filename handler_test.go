@@ -0,0 +1,194 @@
+package locker
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
+)
+
+// newTaskRequest creates a task request to execute the sequence for key
+func newTaskRequest(t *testing.T, key *datastore.Key, sequence int) *http.Request {
+	r, err := instance.NewRequest("POST", "/task/foo", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	json, _ := key.MarshalJSON()
+	r.Header.Set("X-Appengine-TaskName", "task"+strconv.Itoa(sequence))
+	r.Header.Set("X-Lock-Key", string(json))
+	r.Header.Set("X-Lock-Seq", strconv.Itoa(sequence))
+	return r
+}
+
+// scheduleFoo writes a Foo entity ready for the sequence to be executed
+func scheduleFoo(t *testing.T, c context.Context, id int64, sequence int) *datastore.Key {
+	getTime = getTimeDefault
+	k := datastore.NewKey(c, "foo", "", id, nil)
+	f := &Foo{
+		Value: "test",
+		Lock: Lock{
+			Timestamp: getTime(),
+			Sequence:  sequence,
+			Path:      "/task/foo",
+			Status:    StatusPending,
+		},
+	}
+	if _, err := datastore.Put(c, k, f); err != nil {
+		t.Fatal(err)
+	}
+	return k
+}
+
+func fooFactory() Lockable {
+	return new(Foo)
+}
+
+func TestHandlePermanentError(t *testing.T) {
+	r, _ := instance.NewRequest("GET", "/", nil)
+	c := appengine.NewContext(r)
+	k := scheduleFoo(t, c, 10, 1)
+
+	l, _ := NewLocker()
+	h := l.Handle(func(c context.Context, r *http.Request, key *datastore.Key, entity Lockable) error {
+		return fmt.Errorf("charge: %w", Permanent(errors.New("card declined")))
+	}, fooFactory)
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, newTaskRequest(t, k, 1))
+	if w.Code != http.StatusOK {
+		t.Errorf("expected task to be abandoned, got %d", w.Code)
+	}
+
+	lock, _ := l.Inspect(c, k)
+	if lock.Status != StatusFailed || lock.Retries != 0 || lock.LastError != "charge: card declined" {
+		t.Errorf("expected chain to fail without retries %#v", lock)
+	}
+}
+
+func TestHandleRetryAfter(t *testing.T) {
+	r, _ := instance.NewRequest("GET", "/", nil)
+	c := appengine.NewContext(r)
+	k := scheduleFoo(t, c, 11, 1)
+
+	l, _ := NewLocker()
+	h := l.Handle(func(c context.Context, r *http.Request, key *datastore.Key, entity Lockable) error {
+		return RetryAfter(errors.New("rate limited"), time.Minute)
+	}, fooFactory)
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, newTaskRequest(t, k, 1))
+	if w.Code != http.StatusOK {
+		t.Errorf("expected task to be rescheduled, got %d", w.Code)
+	}
+
+	lock, _ := l.Inspect(c, k)
+	if lock.Status != StatusPending || lock.Retries != 1 || lock.RequestID != "" {
+		t.Errorf("expected lock to be released for retry %#v", lock)
+	}
+}
+
+func TestHandlePanic(t *testing.T) {
+	r, _ := instance.NewRequest("GET", "/", nil)
+	c := appengine.NewContext(r)
+	k := scheduleFoo(t, c, 12, 1)
+
+	l, _ := NewLocker()
+	h := l.Handle(func(c context.Context, r *http.Request, key *datastore.Key, entity Lockable) error {
+		var m map[string]int
+		m["boom"]++
+		return nil
+	}, fooFactory)
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, newTaskRequest(t, k, 1))
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("expected task to be retried, got %d", w.Code)
+	}
+
+	lock, _ := l.Inspect(c, k)
+	if lock.RequestID != "" || lock.Retries != 1 || lock.Status != StatusPending {
+		t.Errorf("expected lock to be released %#v", lock)
+	}
+	if !strings.HasPrefix(lock.LastError, "handler panic:") {
+		t.Errorf("expected panic to be recorded, got %q", lock.LastError)
+	}
+}
+
+func TestHandleRePanic(t *testing.T) {
+	r, _ := instance.NewRequest("GET", "/", nil)
+	c := appengine.NewContext(r)
+	k := scheduleFoo(t, c, 13, 1)
+
+	l, _ := NewLocker(RePanic)
+	h := l.Handle(func(c context.Context, r *http.Request, key *datastore.Key, entity Lockable) error {
+		panic("boom")
+	}, fooFactory)
+
+	defer func() {
+		if p := recover(); p != "boom" {
+			t.Errorf("expected re-panic, got %v", p)
+		}
+		lock, _ := l.Inspect(c, k)
+		if lock.RequestID != "" || lock.Retries != 1 {
+			t.Errorf("expected lock to be released before re-panic %#v", lock)
+		}
+	}()
+	h.ServeHTTP(httptest.NewRecorder(), newTaskRequest(t, k, 1))
+}
+
+func TestHandleRePanicAfterTimeout(t *testing.T) {
+	r, _ := instance.NewRequest("GET", "/", nil)
+	c := appengine.NewContext(r)
+	k := scheduleFoo(t, c, 47, 1)
+
+	l, _ := NewLocker(RePanic)
+	h := l.Handle(func(c context.Context, r *http.Request, key *datastore.Key, entity Lockable) error {
+		<-c.Done()
+		panic("boom")
+	}, fooFactory, Timeout(10*time.Millisecond))
+
+	defer func() {
+		if p := recover(); p != "boom" {
+			t.Errorf("expected re-panic of timed out handler, got %v", p)
+		}
+		lock, _ := l.Inspect(c, k)
+		if !strings.HasPrefix(lock.LastError, ErrTaskTimeout.Error()) {
+			t.Errorf("expected timeout error to be recorded, got %q", lock.LastError)
+		}
+	}()
+	h.ServeHTTP(httptest.NewRecorder(), newTaskRequest(t, k, 1))
+}
+
+func TestHandleTimeout(t *testing.T) {
+	r, _ := instance.NewRequest("GET", "/", nil)
+	c := appengine.NewContext(r)
+	k := scheduleFoo(t, c, 14, 1)
+
+	l, _ := NewLocker()
+	h := l.Handle(func(c context.Context, r *http.Request, key *datastore.Key, entity Lockable) error {
+		if _, ok := c.Deadline(); !ok {
+			t.Errorf("expected handler context to have a deadline")
+		}
+		<-c.Done()
+		return c.Err()
+	}, fooFactory, Timeout(10*time.Millisecond))
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, newTaskRequest(t, k, 1))
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("expected task to be retried, got %d", w.Code)
+	}
+
+	lock, _ := l.Inspect(c, k)
+	if lock.RequestID != "" || !strings.HasPrefix(lock.LastError, ErrTaskTimeout.Error()) {
+		t.Errorf("expected lock to be released with timeout error %#v", lock)
+	}
+}
@@ -0,0 +1,139 @@
+package locker
+
+import (
+	"sort"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
+)
+
+type (
+	// Event is a single entry in the lock history of an entity. Events
+	// are stored as child entities of the locked entity so they can be
+	// written in the same transaction as the lock itself.
+	Event struct {
+		// Timestamp is the time that the event happened
+		Timestamp time.Time `datastore:"ts" json:"timestamp"`
+
+		// Type is the type of event (see the Event* constants)
+		Type string `datastore:"type,noindex" json:"type"`
+
+		// RequestID is the request id that caused the event
+		RequestID string `datastore:"req,noindex" json:"request_id"`
+
+		// Sequence is the task sequence number at the time of the event
+		Sequence int `datastore:"seq,noindex" json:"sequence"`
+
+		// Retries is the number of retries at the time of the event
+		Retries int `datastore:"try,noindex" json:"retries"`
+	}
+)
+
+const (
+	// EventSchedule is recorded when a task is scheduled for the entity
+	EventSchedule = "schedule"
+
+	// EventAcquire is recorded when a task obtains the lock
+	EventAcquire = "acquire"
+
+	// EventRetry is recorded when a task obtains the lock for a retry
+	// of a sequence that previously failed
+	EventRetry = "retry"
+
+	// EventRelease is recorded when a lock is cleared after a failure
+	EventRelease = "release"
+
+	// EventOverwrite is recorded when an expired lock is overwritten
+	EventOverwrite = "overwrite"
+
+	// EventExpire is recorded when a task is dropped because its
+	// sequence is behind the entity
+	EventExpire = "expire"
+
+	// EventFanOut is recorded when a task starts child chains and waits
+	// for them to complete before the next task is scheduled
+	EventFanOut = "fanout"
+
+	// EventJoin is recorded when a child chain started by FanOut completes
+	EventJoin = "join"
+
+	// EventWait is recorded when a chain starts waiting for a signal
+	EventWait = "wait"
+
+	// EventSignal is recorded when a signal resumes a waiting chain
+	EventSignal = "signal"
+
+	// EventCompensate is recorded when the compensation task for a step
+	// of a failed workflow is scheduled
+	EventCompensate = "compensate"
+
+	// EventCancel is recorded when a chain is cancelled
+	EventCancel = "cancel"
+
+	// EventPause is recorded when a chain is paused
+	EventPause = "pause"
+
+	// EventResume is recorded when a paused chain is resumed
+	EventResume = "resume"
+
+	// EventReplay is recorded when a chain is reset to run again from
+	// an earlier sequence
+	EventReplay = "replay"
+
+	// EventFail is recorded when a task fails permanently
+	EventFail = "fail"
+
+	// EventComplete is recorded when a task chain is marked complete
+	EventComplete = "complete"
+)
+
+// historyKind is the datastore kind used for lock history entries
+const historyKind = "_lock_event"
+
+// RecordHistory sets the config setting for a locker
+func RecordHistory(l *Locker) error {
+	l.RecordHistory = true
+	return nil
+}
+
+// History returns the recorded lock history for an entity, oldest first.
+// The events are sorted in memory so that no composite index is needed.
+func (l *Locker) History(c context.Context, key *datastore.Key) ([]*Event, error) {
+	var events []*Event
+	q := datastore.NewQuery(historyKind).Ancestor(key)
+	if _, err := q.GetAll(c, &events); err != nil {
+		return nil, err
+	}
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].Timestamp.Before(events[j].Timestamp)
+	})
+	return events, nil
+}
+
+// record writes a history event for the entity if history is enabled.
+// When called with a transaction context the event is committed (or not)
+// along with the lock change that it describes.
+func (l *Locker) record(c context.Context, key *datastore.Key, eventType string, sequence, retries int) error {
+	if !l.RecordHistory {
+		return nil
+	}
+	event := &Event{
+		Timestamp: getTime(),
+		Type:      eventType,
+		RequestID: appengine.RequestID(c),
+		Sequence:  sequence,
+		Retries:   retries,
+	}
+	_, err := datastore.Put(c, datastore.NewIncompleteKey(c, historyKind, key), event)
+	return err
+}
+
+// recordOutside writes a history event that isn't part of a transaction,
+// failure to write it is logged but otherwise ignored
+func (l *Locker) recordOutside(c context.Context, key *datastore.Key, eventType string, sequence, retries int) {
+	if err := l.record(c, key, eventType, sequence, retries); err != nil {
+		l.log().Error(c, "failed to record "+eventType+" event", withError(lockFields(c, key, sequence, retries), err)...)
+	}
+}
@@ -0,0 +1,51 @@
+package locker
+
+import (
+	"testing"
+	"time"
+
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
+)
+
+func TestHistoryRecordsAquire(t *testing.T) {
+	r, _ := instance.NewRequest("GET", "/", nil)
+	r.Header.Set("X-AppEngine-Request-Log-Id", "history")
+	c := appengine.NewContext(r)
+
+	getTime = getTimeDefault
+	f := &Foo{
+		Value: "test",
+		Lock: Lock{
+			Timestamp: getTime().Add(-time.Minute),
+			Sequence:  1,
+		},
+	}
+
+	k := datastore.NewKey(c, "foo", "", 2, nil)
+	if _, err := datastore.Put(c, k, f); err != nil {
+		t.Fatal(err)
+	}
+
+	l, _ := NewLocker(RecordHistory)
+	if err := l.Aquire(c, k, f, 1); err != nil {
+		t.Fatalf("failed to lock %v", err)
+	}
+	if err := l.Aquire(c, k, new(Foo), 0); err != ErrTaskExpired {
+		t.Errorf("expected expired task, got %v", err)
+	}
+
+	events, err := l.History(c, k)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 {
+		t.Fatalf("expected 2 events, got %d", len(events))
+	}
+	if events[0].Type != EventAcquire || events[0].RequestID != "history" {
+		t.Errorf("unexpected first event %#v", events[0])
+	}
+	if events[1].Type != EventExpire || events[1].Sequence != 0 {
+		t.Errorf("unexpected second event %#v", events[1])
+	}
+}
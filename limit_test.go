@@ -0,0 +1,146 @@
+package locker
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
+)
+
+func TestMaxSequence(t *testing.T) {
+	r, _ := instance.NewRequest("GET", "/", nil)
+	c := appengine.NewContext(r)
+
+	getTime = getTimeDefault
+	rec := new(recordingNotifier)
+	l, _ := NewLocker(MaxSequence(2), Alerts(rec))
+	k := datastore.NewKey(c, "foo", "", 41, nil)
+	if err := l.Schedule(c, k, &Foo{Value: "test"}, "/task/foo", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// a handler that schedules forever
+	h := l.Handle(func(c context.Context, r *http.Request, key *datastore.Key, entity Lockable) error {
+		return l.Schedule(c, key, entity, "/task/foo", nil)
+	}, fooFactory)
+	for seq := 1; seq <= 2; seq++ {
+		h.ServeHTTP(httptest.NewRecorder(), newTaskRequest(t, k, seq))
+	}
+
+	lock, _ := l.Inspect(c, k)
+	if lock.Status != StatusFailed || lock.Sequence != 2 || lock.LastError != ErrChainLimit.Error() {
+		t.Errorf("expected chain to fail at the limit %#v", lock)
+	}
+	if len(rec.alerts) != 1 || rec.alerts[0].Reason != ReasonLimit {
+		t.Errorf("expected limit alert, got %v", rec.alerts)
+	}
+}
+
+func TestMaxSequenceIgnored(t *testing.T) {
+	r, _ := instance.NewRequest("GET", "/", nil)
+	c := appengine.NewContext(r)
+
+	getTime = getTimeDefault
+	rec := new(recordingNotifier)
+	l, _ := NewLocker(MaxSequence(1), Alerts(rec))
+	k := datastore.NewKey(c, "foo", "", 159, nil)
+	if err := l.Schedule(c, k, &Foo{Value: "test"}, "/task/foo", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// a handler that ignores the limit error still fails the chain
+	h := l.Handle(func(c context.Context, r *http.Request, key *datastore.Key, entity Lockable) error {
+		if err := l.Schedule(c, key, entity, "/task/foo", nil); err != ErrChainLimit {
+			t.Errorf("expected limit error, got %v", err)
+		}
+		return nil
+	}, fooFactory)
+	h.ServeHTTP(httptest.NewRecorder(), newTaskRequest(t, k, 1))
+
+	lock, _ := l.Inspect(c, k)
+	if lock.Status != StatusFailed || lock.RequestID != "" || lock.Sequence != 1 {
+		t.Errorf("expected chain to be failed and released %#v", lock)
+	}
+	if len(rec.alerts) != 1 || rec.alerts[0].Reason != ReasonLimit {
+		t.Errorf("expected limit alert, got %v", rec.alerts)
+	}
+}
+
+func TestMaxChainDuration(t *testing.T) {
+	r, _ := instance.NewRequest("GET", "/", nil)
+	c := appengine.NewContext(r)
+
+	getTime = getTimeDefault
+	l, _ := NewLocker(MaxChainDuration(time.Hour), Alerts(new(recordingNotifier)))
+	k := datastore.NewKey(c, "foo", "", 42, nil)
+	if err := l.Schedule(c, k, &Foo{Value: "test"}, "/task/foo", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	n := time.Now().Add(2 * time.Hour)
+	getTime = func() time.Time {
+		return n
+	}
+	defer func() { getTime = getTimeDefault }()
+	if err := l.Aquire(c, k, new(Foo), 1); err != ErrChainLimit {
+		t.Fatalf("expected task past the deadline to be dropped, got %v", err)
+	}
+	if lock, _ := l.Inspect(c, k); lock.Status != StatusFailed || lock.RequestID != "" {
+		t.Errorf("expected chain to fail after the deadline %#v", lock)
+	}
+}
+
+func TestMaxSequenceCompensation(t *testing.T) {
+	r, _ := instance.NewRequest("GET", "/", nil)
+	c := appengine.NewContext(r)
+
+	getTime = getTimeDefault
+	var ran []string
+	step := func(name string) TaskHandler {
+		return func(c context.Context, r *http.Request, key *datastore.Key, entity Lockable) error {
+			ran = append(ran, name)
+			return nil
+		}
+	}
+	w := NewWorkflow("/task/foo")
+	w.Step("charge", step("charge")).Compensate(step("refund"))
+	w.Step("reserve", step("reserve")).Compensate(step("release"))
+	w.Step("notify", step("notify"))
+	w.Step("ship", step("ship"))
+
+	// the chain fails at the limit before ship, the compensation tasks run
+	// past the limit
+	rec := new(recordingNotifier)
+	l, _ := NewLocker(MaxSequence(3), Alerts(rec))
+	k := datastore.NewKey(c, "foo", "", 156, nil)
+	if err := l.Start(c, k, &Foo{Value: "test"}, w, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	h := l.HandleWorkflow(w, fooFactory)
+	for seq := 1; seq <= 5; seq++ {
+		h.ServeHTTP(httptest.NewRecorder(), newTaskRequest(t, k, seq))
+	}
+
+	expected := []string{"charge", "reserve", "notify", "release", "refund"}
+	if len(ran) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, ran)
+	}
+	for i := range expected {
+		if ran[i] != expected[i] {
+			t.Errorf("expected %v, got %v", expected, ran)
+			break
+		}
+	}
+	lock, _ := l.Inspect(c, k)
+	if lock.Status != StatusFailed || lock.Compensating || len(lock.Compensated) != 2 {
+		t.Errorf("expected compensated chain to be failed %#v", lock)
+	}
+	if len(rec.alerts) != 1 {
+		t.Errorf("expected a single limit alert, got %v", rec.alerts)
+	}
+}
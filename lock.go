@@ -2,6 +2,8 @@ package locker
 
 import (
 	"time"
+
+	"google.golang.org/appengine/datastore"
 )
 
 type (
@@ -32,8 +34,87 @@ type (
 		// Sequence is the task sequence number
 		Sequence int `datastore:"lock_seq,noindex"`
 
+		// Status is the state of the task chain (see the Status* constants).
+		// It is indexed so that entities can be queried by status.
+		Status string `datastore:"lock_status"`
+
+		// Started is the time that the chain was first scheduled
+		Started time.Time `datastore:"lock_started,noindex"`
+
+		// Limit is the maximum sequence of the chain, recorded from the
+		// MaxSequence setting when the chain started
+		Limit int `datastore:"lock_limit,noindex"`
+
+		// Deadline is the time the chain must finish by, recorded from the
+		// MaxChainDuration setting when the chain started
+		Deadline time.Time `datastore:"lock_deadline,noindex"`
+
+		// Control is set when the chain has been cancelled or paused (see
+		// the Control* constants)
+		Control string `datastore:"lock_control,noindex"`
+
 		// Retries is the number of retries that have been attempted
 		Retries int `datastore:"lock_try,noindex"`
+
+		// Path is the url of the task handler for the current sequence
+		Path string `datastore:"lock_path,noindex"`
+
+		// Queue is the name of the task queue that the task for the
+		// current sequence was scheduled on
+		Queue string `datastore:"lock_queue,noindex"`
+
+		// Params are the url encoded params of the task for the current
+		// sequence, only stored with the PersistParams setting
+		Params string `datastore:"lock_params,noindex"`
+
+		// Step is the name of the workflow step for the current sequence
+		Step string `datastore:"lock_step,noindex"`
+
+		// Compensate is the list of completed workflow steps that have a
+		// compensation handler, in the order they were run
+		Compensate []string `datastore:"lock_compensate,noindex"`
+
+		// Compensated is the list of workflow steps whose compensation
+		// handler succeeded after the chain failed
+		Compensated []string `datastore:"lock_compensated,noindex"`
+
+		// Compensating is set while the compensation handlers are run
+		Compensating bool `datastore:"lock_compensating,noindex"`
+
+		// LastError is the last error returned by the task handler
+		LastError string `datastore:"lock_err,noindex"`
+
+		// ErrorTimestamp is the time that the last error happened
+		ErrorTimestamp time.Time `datastore:"lock_err_ts,noindex"`
+
+		// ErrorSequence is the task sequence number that the last error
+		// happened in
+		ErrorSequence int `datastore:"lock_err_seq,noindex"`
+
+		// Parent is the key of the entity that started this chain with
+		// FanOut or StartChild, it continues when all of its children have
+		// completed or failed
+		Parent *datastore.Key `datastore:"lock_parent,noindex"`
+
+		// ParentSequence is the sequence that the parent continues with
+		ParentSequence int `datastore:"lock_parent_seq,noindex"`
+
+		// Pending is the number of child chains that are still running
+		Pending int `datastore:"lock_pending,noindex"`
+
+		// Failed is the number of child chains that failed permanently
+		Failed int `datastore:"lock_failed,noindex"`
+
+		// Wake is the time that a delayed task is due to run or a signal
+		// times out. It is indexed so that timers whose tasks were lost can
+		// be found (see Reap).
+		Wake time.Time `datastore:"lock_wake"`
+
+		// Signal is the name of the signal that the chain is waiting for
+		Signal string `datastore:"lock_signal,noindex"`
+
+		// Payload is the payload of the signal that resumed the chain
+		Payload []byte `datastore:"lock_payload,noindex"`
 	}
 
 	// Lockable is the interface that lockable entities must implement
@@ -48,6 +129,24 @@ type (
 	}
 )
 
+const (
+	// StatusPending means a task is scheduled or waiting to be retried
+	StatusPending = "pending"
+
+	// StatusRunning means a task holds the lock
+	StatusRunning = "running"
+
+	// StatusCompleted means the task chain has completed
+	StatusCompleted = "completed"
+
+	// StatusFailed means the task chain has failed permanently
+	StatusFailed = "failed"
+
+	// StatusWaiting means the task chain is waiting for something to
+	// happen before it continues, e.g. child chains or a timer
+	StatusWaiting = "waiting"
+)
+
 func (l *Lock) getLock() *Lock {
 	return l
 }
@@ -60,4 +159,58 @@ func (l *Lock) Complete() {
 	l.RequestID = ""
 	l.Retries = 0
 	l.Sequence = -1
+	l.Status = StatusCompleted
+}
+
+// next prepares the lock for the next task in the chain
+func (l *Lock) next(path string) {
+	l.Timestamp = getTime()
+	l.RequestID = ""
+	l.Retries = 0
+	l.Sequence++
+	l.Path = path
+	l.Params = ""
+	l.Status = StatusPending
+	l.Wake = time.Time{}
+	l.Signal = ""
+	l.Payload = nil
+}
+
+// ended returns true if there is no chain in progress, the next task
+// starts a new one
+func (l *Lock) ended() bool {
+	return l.Sequence <= 0 || l.Status == StatusCompleted || l.Status == StatusFailed
+}
+
+// nextExceeded returns true if the next task of a chain in progress would
+// go past its limits
+func (l *Lock) nextExceeded() bool {
+	if l.ended() {
+		return false
+	}
+	next := *l
+	next.Sequence++
+	return next.exceeded()
+}
+
+// exceeded returns true if the chain has gone past its limits. The
+// compensation of a failed workflow isn't limited so that it can finish.
+func (l *Lock) exceeded() bool {
+	if l.Compensating {
+		return false
+	}
+	if l.Limit > 0 && l.Sequence > l.Limit {
+		return true
+	}
+	return !l.Deadline.IsZero() && getTime().After(l.Deadline)
+}
+
+// setError records the error returned by a task handler
+func (l *Lock) setError(err error) {
+	if err == nil {
+		return
+	}
+	l.LastError = err.Error()
+	l.ErrorTimestamp = getTime()
+	l.ErrorSequence = l.Sequence
 }
@@ -5,7 +5,9 @@ import (
 )
 
 type (
-	// Locker is the instance that stores configuration
+	// Locker is the instance that stores configuration. It should be
+	// created with NewLocker, a zero Locker uses the default hooks but
+	// none of the default timings.
 	Locker struct {
 		// Once a lock has been held longer than this duration the logs API
 		// will be checked to determine if the request has completed or not
@@ -16,22 +18,88 @@ type (
 		// task has died. 10 mins is the task timeout on a frontend instance.
 		LeaseTimeout time.Duration
 
+		// DeadlineMargin is subtracted from the LeaseTimeout to give the
+		// deadline for task handlers, allowing time to release the lock
+		DeadlineMargin time.Duration
+
 		// MaxRetries is the maximum number of retries to allow
 		MaxRetries int
 
+		// MaxSequence is the maximum sequence number a chain can reach
+		// before it is failed with ErrChainLimit. Zero means no limit.
+		MaxSequence int
+
+		// MaxChainDuration is the maximum time a chain can run for from
+		// when it was first scheduled before it is failed with ErrChainLimit.
+		// Zero means no limit.
+		MaxChainDuration time.Duration
+
+		// PersistParams stores the params of each task on the lock so that
+		// a task re-scheduled by Retry, Reap, Resume or Replay gets them
+		PersistParams bool
+
+		// Backoff is the retry policy for lock contention and handler
+		// failures. If set, the locker re-schedules the task for the same
+		// sequence with the delay rather than failing the request and
+		// relying on the queue retry settings.
+		Backoff RetryPolicy
+
+		// RePanic will re-panic after a panicking task handler has been
+		// recovered and the lock released, e.g. to let a crash reporter
+		// see it. By default the panic is treated as a handler failure.
+		RePanic bool
+
+		// SignalTimeout is the time a chain will wait for a signal before
+		// it times out (see WaitForSignal). Zero means wait forever.
+		SignalTimeout time.Duration
+
+		// FailOnSignalTimeout fails a chain that timed out waiting for a
+		// signal instead of continuing it without a payload
+		FailOnSignalTimeout bool
+
 		// LogVerbose sets verbose logging of lock operations
 		LogVerbose bool
 
-		// AlertOnFailure will set an alert email to be sent to admins if
+		// Log is the structured logger for lock operations
+		Log Logger
+
+		// Metrics receives measurements of lock operations
+		Metrics MetricsHook
+
+		// Tracing creates spans for lock operations and propagates the
+		// trace context between tasks
+		Tracing TraceHook
+
+		// RecordHistory enables writing an audit trail of lock operations
+		// as child entities of each locked entity (see History)
+		RecordHistory bool
+
+		// AlertOnFailure will set an alert to be sent to admins if
 		// a task fails permanently (more than the MaxRetries reached)
 		AlertOnFailure bool
 
-		// AlertOnOverwrite will set an alert email to be sent to admins
+		// AlertOnOverwrite will set an alert to be sent to admins
 		// if a lock is being overwritten. This is normally an exceptional
 		// situation but may investigation to ensure correct operation of
 		// the system
 		AlertOnOverwrite bool
 
+		// Alerts is the notifier used to send alerts, the default is to
+		// email the app admins
+		Alerts Notifier
+
+		// AlertFields is the allowlist of entity properties to include in
+		// alerts. If empty, all properties are included.
+		AlertFields []string
+
+		// AlertRedact is the list of entity properties whose values are
+		// replaced in alerts
+		AlertRedact []string
+
+		// AdminURL is the url that the AdminHandler is mounted at, used
+		// to include links to admin actions in alerts
+		AdminURL string
+
 		// DefaultQueue is the name of the task-queue to schedule tasks on.
 		// The default (empty string) is to use the default task queue.
 		DefaultQueue string
@@ -50,9 +118,14 @@ type (
 // NewLocker creates a new configured Locker instance
 func NewLocker(options ...Option) (*Locker, error) {
 	locker := &Locker{
-		LeaseDuration: time.Duration(1) * time.Minute,
-		LeaseTimeout:  time.Duration(10)*time.Minute + time.Duration(30)*time.Second,
-		MaxRetries:    10,
+		LeaseDuration:  time.Duration(1) * time.Minute,
+		LeaseTimeout:   time.Duration(10)*time.Minute + time.Duration(30)*time.Second,
+		DeadlineMargin: time.Duration(30) * time.Second,
+		MaxRetries:     10,
+		Metrics:        nopMetrics{},
+		Tracing:        w3cTracer{},
+		Log:            appengineLogger{},
+		Alerts:         new(EmailNotifier),
 	}
 
 	for _, option := range options {
@@ -79,6 +152,14 @@ func LeaseTimeout(duration time.Duration) func(*Locker) error {
 	}
 }
 
+// DeadlineMargin sets the config setting for a locker
+func DeadlineMargin(margin time.Duration) func(*Locker) error {
+	return func(l *Locker) error {
+		l.DeadlineMargin = margin
+		return nil
+	}
+}
+
 // MaxRetries sets the config setting for a locker
 func MaxRetries(retries int) func(*Locker) error {
 	return func(l *Locker) error {
@@ -87,6 +168,28 @@ func MaxRetries(retries int) func(*Locker) error {
 	}
 }
 
+// MaxSequence sets the config setting for a locker
+func MaxSequence(sequence int) func(*Locker) error {
+	return func(l *Locker) error {
+		l.MaxSequence = sequence
+		return nil
+	}
+}
+
+// MaxChainDuration sets the config setting for a locker
+func MaxChainDuration(duration time.Duration) func(*Locker) error {
+	return func(l *Locker) error {
+		l.MaxChainDuration = duration
+		return nil
+	}
+}
+
+// RePanic sets the config setting for a locker
+func RePanic(l *Locker) error {
+	l.RePanic = true
+	return nil
+}
+
 // LogVerbose sets the config setting for a locker
 func LogVerbose(l *Locker) error {
 	l.LogVerbose = true
@@ -0,0 +1,91 @@
+// Package lockerotel provides OpenTelemetry adapters for locker
+package lockerotel // import "github.com/captaincodeman/datastore-locker/lockerotel"
+
+import (
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"golang.org/x/net/context"
+
+	"github.com/captaincodeman/datastore-locker"
+)
+
+type (
+	// Metrics implements locker.MetricsHook using OpenTelemetry instruments
+	Metrics struct {
+		acquire        metric.Int64Counter
+		acquireLatency metric.Float64Histogram
+		retries        metric.Int64Counter
+		failures       metric.Int64Counter
+		overwrites     metric.Int64Counter
+		hold           metric.Float64Histogram
+	}
+)
+
+var _ locker.MetricsHook = (*Metrics)(nil)
+
+// NewMetrics creates the locker instruments using meter
+func NewMetrics(meter metric.Meter) (*Metrics, error) {
+	m := new(Metrics)
+	var err error
+	if m.acquire, err = meter.Int64Counter("locker.acquire",
+		metric.WithDescription("Lock attempts by outcome.")); err != nil {
+		return nil, err
+	}
+	if m.acquireLatency, err = meter.Float64Histogram("locker.acquire.duration",
+		metric.WithDescription("Time taken to attempt a lock."), metric.WithUnit("s")); err != nil {
+		return nil, err
+	}
+	if m.retries, err = meter.Int64Counter("locker.retries",
+		metric.WithDescription("Failed tasks released for retry.")); err != nil {
+		return nil, err
+	}
+	if m.failures, err = meter.Int64Counter("locker.failures",
+		metric.WithDescription("Tasks that failed permanently.")); err != nil {
+		return nil, err
+	}
+	if m.overwrites, err = meter.Int64Counter("locker.overwrites",
+		metric.WithDescription("Expired locks that were overwritten.")); err != nil {
+		return nil, err
+	}
+	if m.hold, err = meter.Float64Histogram("locker.hold.duration",
+		metric.WithDescription("Time that handlers held the lock."), metric.WithUnit("s")); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func labels(kind, queue string) metric.MeasurementOption {
+	return metric.WithAttributes(attribute.String("kind", kind), attribute.String("queue", queue))
+}
+
+// Acquire implements locker.MetricsHook
+func (m *Metrics) Acquire(c context.Context, kind, queue, outcome string, latency time.Duration) {
+	m.acquire.Add(c, 1, metric.WithAttributes(
+		attribute.String("kind", kind),
+		attribute.String("queue", queue),
+		attribute.String("outcome", outcome),
+	))
+	m.acquireLatency.Record(c, latency.Seconds(), labels(kind, queue))
+}
+
+// Retry implements locker.MetricsHook
+func (m *Metrics) Retry(c context.Context, kind, queue string) {
+	m.retries.Add(c, 1, labels(kind, queue))
+}
+
+// Failure implements locker.MetricsHook
+func (m *Metrics) Failure(c context.Context, kind, queue string) {
+	m.failures.Add(c, 1, labels(kind, queue))
+}
+
+// Overwrite implements locker.MetricsHook
+func (m *Metrics) Overwrite(c context.Context, kind, queue string) {
+	m.overwrites.Add(c, 1, labels(kind, queue))
+}
+
+// Hold implements locker.MetricsHook
+func (m *Metrics) Hold(c context.Context, kind, queue string, duration time.Duration) {
+	m.hold.Record(c, duration.Seconds(), labels(kind, queue))
+}
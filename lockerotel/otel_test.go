@@ -0,0 +1,54 @@
+package lockerotel
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	metricnoop "go.opentelemetry.io/otel/metric/noop"
+	"go.opentelemetry.io/otel/trace"
+	tracenoop "go.opentelemetry.io/otel/trace/noop"
+	"golang.org/x/net/context"
+
+	"github.com/captaincodeman/datastore-locker"
+)
+
+func TestMetrics(t *testing.T) {
+	m, err := NewMetrics(metricnoop.NewMeterProvider().Meter("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := context.Background()
+	m.Acquire(c, "foo", "", locker.AcquireOK, time.Millisecond)
+	m.Retry(c, "foo", "")
+	m.Failure(c, "foo", "")
+	m.Overwrite(c, "foo", "")
+	m.Hold(c, "foo", "", time.Second)
+}
+
+func TestTracerPropagation(t *testing.T) {
+	tr := NewTracer(tracenoop.NewTracerProvider().Tracer("test"))
+
+	sc := trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    trace.TraceID{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16},
+		SpanID:     trace.SpanID{1, 2, 3, 4, 5, 6, 7, 8},
+		TraceFlags: trace.FlagsSampled,
+	})
+	c := trace.ContextWithSpanContext(context.Background(), sc)
+
+	header := http.Header{}
+	tr.Inject(c, header)
+	if header.Get("traceparent") == "" {
+		t.Fatal("expected traceparent header")
+	}
+
+	got := trace.SpanContextFromContext(tr.Extract(context.Background(), header))
+	if got.TraceID() != sc.TraceID() || got.SpanID() != sc.SpanID() {
+		t.Errorf("expected %s/%s got %s/%s", sc.TraceID(), sc.SpanID(), got.TraceID(), got.SpanID())
+	}
+
+	_, end := tr.Start(c, "locker.test")
+	end(errors.New("failed"))
+}
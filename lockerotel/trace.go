@@ -0,0 +1,53 @@
+package lockerotel
+
+import (
+	"net/http"
+
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/net/context"
+
+	"github.com/captaincodeman/datastore-locker"
+)
+
+type (
+	// Tracer implements locker.TraceHook using an OpenTelemetry tracer
+	// and the W3C trace context propagator
+	Tracer struct {
+		tracer     trace.Tracer
+		propagator propagation.TextMapPropagator
+	}
+)
+
+var _ locker.TraceHook = (*Tracer)(nil)
+
+// NewTracer creates a locker.TraceHook that records spans with tracer
+func NewTracer(tracer trace.Tracer) *Tracer {
+	return &Tracer{
+		tracer:     tracer,
+		propagator: propagation.TraceContext{},
+	}
+}
+
+// Extract implements locker.TraceHook
+func (t *Tracer) Extract(c context.Context, header http.Header) context.Context {
+	return t.propagator.Extract(c, propagation.HeaderCarrier(header))
+}
+
+// Inject implements locker.TraceHook
+func (t *Tracer) Inject(c context.Context, header http.Header) {
+	t.propagator.Inject(c, propagation.HeaderCarrier(header))
+}
+
+// Start implements locker.TraceHook
+func (t *Tracer) Start(c context.Context, name string) (context.Context, func(error)) {
+	c, span := t.tracer.Start(c, name)
+	return c, func(err error) {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}
+}
@@ -0,0 +1,97 @@
+// Package lockerprom provides a Prometheus adapter for locker metrics
+package lockerprom // import "github.com/captaincodeman/datastore-locker/lockerprom"
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/net/context"
+
+	"github.com/captaincodeman/datastore-locker"
+)
+
+type (
+	// Metrics implements locker.MetricsHook using Prometheus collectors
+	Metrics struct {
+		acquire        *prometheus.CounterVec
+		acquireLatency *prometheus.HistogramVec
+		retries        *prometheus.CounterVec
+		failures       *prometheus.CounterVec
+		overwrites     *prometheus.CounterVec
+		hold           *prometheus.HistogramVec
+	}
+)
+
+var _ locker.MetricsHook = (*Metrics)(nil)
+
+// New creates the locker collectors and registers them with reg
+func New(reg prometheus.Registerer) (*Metrics, error) {
+	labels := []string{"kind", "queue"}
+	m := &Metrics{
+		acquire: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "locker",
+			Name:      "acquire_total",
+			Help:      "Lock attempts by outcome.",
+		}, []string{"kind", "queue", "outcome"}),
+		acquireLatency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "locker",
+			Name:      "acquire_seconds",
+			Help:      "Time taken to attempt a lock.",
+			Buckets:   prometheus.DefBuckets,
+		}, labels),
+		retries: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "locker",
+			Name:      "retries_total",
+			Help:      "Failed tasks released for retry.",
+		}, labels),
+		failures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "locker",
+			Name:      "failures_total",
+			Help:      "Tasks that failed permanently.",
+		}, labels),
+		overwrites: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "locker",
+			Name:      "overwrites_total",
+			Help:      "Expired locks that were overwritten.",
+		}, labels),
+		hold: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "locker",
+			Name:      "hold_seconds",
+			Help:      "Time that handlers held the lock.",
+			Buckets:   prometheus.ExponentialBuckets(0.1, 2, 14),
+		}, labels),
+	}
+
+	for _, c := range []prometheus.Collector{m.acquire, m.acquireLatency, m.retries, m.failures, m.overwrites, m.hold} {
+		if err := reg.Register(c); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// Acquire implements locker.MetricsHook
+func (m *Metrics) Acquire(c context.Context, kind, queue, outcome string, latency time.Duration) {
+	m.acquire.WithLabelValues(kind, queue, outcome).Inc()
+	m.acquireLatency.WithLabelValues(kind, queue).Observe(latency.Seconds())
+}
+
+// Retry implements locker.MetricsHook
+func (m *Metrics) Retry(c context.Context, kind, queue string) {
+	m.retries.WithLabelValues(kind, queue).Inc()
+}
+
+// Failure implements locker.MetricsHook
+func (m *Metrics) Failure(c context.Context, kind, queue string) {
+	m.failures.WithLabelValues(kind, queue).Inc()
+}
+
+// Overwrite implements locker.MetricsHook
+func (m *Metrics) Overwrite(c context.Context, kind, queue string) {
+	m.overwrites.WithLabelValues(kind, queue).Inc()
+}
+
+// Hold implements locker.MetricsHook
+func (m *Metrics) Hold(c context.Context, kind, queue string, duration time.Duration) {
+	m.hold.WithLabelValues(kind, queue).Observe(duration.Seconds())
+}
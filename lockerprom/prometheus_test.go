@@ -0,0 +1,61 @@
+package lockerprom
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/net/context"
+
+	"github.com/captaincodeman/datastore-locker"
+)
+
+func TestMetrics(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	m, err := New(reg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := context.Background()
+	m.Acquire(c, "foo", "", locker.AcquireOK, time.Millisecond)
+	m.Acquire(c, "foo", "", locker.AcquireLockFailed, time.Millisecond)
+	m.Retry(c, "foo", "")
+	m.Failure(c, "foo", "")
+	m.Overwrite(c, "foo", "")
+	m.Hold(c, "foo", "", time.Second)
+
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	counts := make(map[string]int)
+	for _, f := range families {
+		for _, metric := range f.GetMetric() {
+			switch {
+			case metric.GetCounter() != nil:
+				counts[f.GetName()] += int(metric.GetCounter().GetValue())
+			case metric.GetHistogram() != nil:
+				counts[f.GetName()] += int(metric.GetHistogram().GetSampleCount())
+			}
+		}
+	}
+
+	expected := map[string]int{
+		"locker_acquire_total":    2,
+		"locker_acquire_seconds":  2,
+		"locker_retries_total":    1,
+		"locker_failures_total":   1,
+		"locker_overwrites_total": 1,
+		"locker_hold_seconds":     1,
+	}
+	for name, count := range expected {
+		if counts[name] != count {
+			t.Errorf("expected %s to be %d, got %d", name, count, counts[name])
+		}
+	}
+
+	if _, err := New(reg); err == nil {
+		t.Error("expected registering twice to fail")
+	}
+}
@@ -0,0 +1,148 @@
+package locker
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/context"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
+	"google.golang.org/appengine/log"
+)
+
+type (
+	// Logger writes structured log entries for lock operations so that
+	// lock events can be filtered by field rather than parsing messages.
+	// The default writes to the appengine log with the fields appended
+	// to the message as key=value pairs.
+	Logger interface {
+		Debug(c context.Context, msg string, fields ...Field)
+		Info(c context.Context, msg string, fields ...Field)
+		Warning(c context.Context, msg string, fields ...Field)
+		Error(c context.Context, msg string, fields ...Field)
+	}
+
+	// Field is a named value attached to a log entry
+	Field struct {
+		Key   string
+		Value interface{}
+	}
+
+	// appengineLogger is the default Logger using the appengine log API
+	appengineLogger struct{}
+
+	// slogLogger adapts a log/slog Logger
+	slogLogger struct {
+		logger *slog.Logger
+	}
+)
+
+// Field keys used for lock log entries
+const (
+	FieldKey       = "key"
+	FieldKind      = "kind"
+	FieldSequence  = "sequence"
+	FieldRetries   = "retries"
+	FieldRequestID = "request_id"
+	FieldHolder    = "holder"
+	FieldLockedAt  = "locked_at"
+	FieldError     = "error"
+	FieldReason    = "reason"
+	FieldCount     = "count"
+	FieldSince     = "since"
+	FieldExamples  = "examples"
+)
+
+// Log sets the config setting for a locker
+func Log(logger Logger) func(*Locker) error {
+	return func(l *Locker) error {
+		l.Log = logger
+		return nil
+	}
+}
+
+// log returns the logger of the locker or the default if it isn't set,
+// e.g. for a Locker that wasn't created with NewLocker
+func (l *Locker) log() Logger {
+	if l.Log == nil {
+		return appengineLogger{}
+	}
+	return l.Log
+}
+
+// NewSlogLogger creates a Logger that writes to a log/slog Logger
+func NewSlogLogger(logger *slog.Logger) Logger {
+	return &slogLogger{logger: logger}
+}
+
+// lockFields returns the standard fields that identify a lock operation
+func lockFields(c context.Context, key *datastore.Key, sequence, retries int) []Field {
+	return []Field{
+		{FieldKey, key.String()},
+		{FieldKind, key.Kind()},
+		{FieldSequence, sequence},
+		{FieldRetries, retries},
+		{FieldRequestID, appengine.RequestID(c)},
+	}
+}
+
+// withError adds an error field to a set of fields
+func withError(fields []Field, err error) []Field {
+	return append(fields[:len(fields):len(fields)], Field{FieldError, err.Error()})
+}
+
+func (appengineLogger) Debug(c context.Context, msg string, fields ...Field) {
+	log.Debugf(c, "%s", formatFields(msg, fields))
+}
+
+func (appengineLogger) Info(c context.Context, msg string, fields ...Field) {
+	log.Infof(c, "%s", formatFields(msg, fields))
+}
+
+func (appengineLogger) Warning(c context.Context, msg string, fields ...Field) {
+	log.Warningf(c, "%s", formatFields(msg, fields))
+}
+
+func (appengineLogger) Error(c context.Context, msg string, fields ...Field) {
+	log.Errorf(c, "%s", formatFields(msg, fields))
+}
+
+// formatFields appends the fields to the message as key=value pairs
+func formatFields(msg string, fields []Field) string {
+	buf := bytes.NewBufferString(msg)
+	for _, f := range fields {
+		value := fmt.Sprint(f.Value)
+		if value == "" || strings.ContainsAny(value, " \"=") {
+			value = strconv.Quote(value)
+		}
+		fmt.Fprintf(buf, " %s=%s", f.Key, value)
+	}
+	return buf.String()
+}
+
+func (l *slogLogger) Debug(c context.Context, msg string, fields ...Field) {
+	l.logger.DebugContext(c, msg, attrs(fields)...)
+}
+
+func (l *slogLogger) Info(c context.Context, msg string, fields ...Field) {
+	l.logger.InfoContext(c, msg, attrs(fields)...)
+}
+
+func (l *slogLogger) Warning(c context.Context, msg string, fields ...Field) {
+	l.logger.WarnContext(c, msg, attrs(fields)...)
+}
+
+func (l *slogLogger) Error(c context.Context, msg string, fields ...Field) {
+	l.logger.ErrorContext(c, msg, attrs(fields)...)
+}
+
+func attrs(fields []Field) []interface{} {
+	args := make([]interface{}, len(fields))
+	for i, f := range fields {
+		args[i] = slog.Any(f.Key, f.Value)
+	}
+	return args
+}
@@ -0,0 +1,29 @@
+package locker
+
+import (
+	"bytes"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestFormatFields(t *testing.T) {
+	fields := withError([]Field{{FieldKind, "foo"}, {FieldSequence, 2}, {FieldRequestID, ""}}, errors.New("it broke"))
+	got := formatFields("handler failed", fields)
+	want := `handler failed kind=foo sequence=2 request_id="" error="it broke"`
+	if got != want {
+		t.Errorf("expected %s got %s", want, got)
+	}
+}
+
+func TestSlogLogger(t *testing.T) {
+	buf := new(bytes.Buffer)
+	logger := NewSlogLogger(slog.New(slog.NewTextHandler(buf, nil)))
+	logger.Warning(context.Background(), "lock failed", Field{FieldKind, "foo"}, Field{FieldSequence, 3})
+	if !strings.Contains(buf.String(), `level=WARN msg="lock failed" kind=foo sequence=3`) {
+		t.Errorf("unexpected log output %s", buf.String())
+	}
+}
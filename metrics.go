@@ -0,0 +1,88 @@
+package locker
+
+import (
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+type (
+	// MetricsHook receives measurements of lock operations. Every call is
+	// labelled with the entity kind and the name of the queue the task is
+	// running on (empty for the default queue).
+	// See the lockerprom and lockerotel packages for adapters.
+	MetricsHook interface {
+		// Acquire is called with the outcome of every lock attempt
+		// (see the Acquire* constants) and the time it took
+		Acquire(c context.Context, kind, queue, outcome string, latency time.Duration)
+
+		// Retry is called when a failed task releases its lock to be retried
+		Retry(c context.Context, kind, queue string)
+
+		// Failure is called when a task fails permanently
+		Failure(c context.Context, kind, queue string)
+
+		// Overwrite is called when an expired lock is overwritten
+		Overwrite(c context.Context, kind, queue string)
+
+		// Hold is called with the time a handler held the lock for
+		Hold(c context.Context, kind, queue string, duration time.Duration)
+	}
+
+	// nopMetrics is the default MetricsHook that discards everything
+	nopMetrics struct{}
+)
+
+const (
+	// AcquireOK is the outcome when the lock was obtained
+	AcquireOK = "ok"
+
+	// AcquireLockFailed is the outcome when the lock was already held
+	// or couldn't be obtained (ErrLockFailed)
+	AcquireLockFailed = "lock_failed"
+
+	// AcquireExpired is the outcome when the task was behind the entity
+	// sequence (ErrTaskExpired)
+	AcquireExpired = "expired"
+
+	// AcquireStopped is the outcome when the chain has been cancelled,
+	// paused or exceeded its limits (ErrTaskCancelled, ErrTaskPaused or
+	// ErrChainLimit)
+	AcquireStopped = "stopped"
+)
+
+// Metrics sets the config setting for a locker
+func Metrics(metrics MetricsHook) func(*Locker) error {
+	return func(l *Locker) error {
+		l.Metrics = metrics
+		return nil
+	}
+}
+
+// metrics returns the metrics hook of the locker or the default if it isn't set,
+// e.g. for a Locker that wasn't created with NewLocker
+func (l *Locker) metrics() MetricsHook {
+	if l.Metrics == nil {
+		return nopMetrics{}
+	}
+	return l.Metrics
+}
+
+// acquireOutcome maps the result of Aquire to a metrics outcome label
+func acquireOutcome(err error) string {
+	switch err {
+	case nil:
+		return AcquireOK
+	case ErrTaskExpired:
+		return AcquireExpired
+	case ErrTaskCancelled, ErrTaskPaused, ErrChainLimit:
+		return AcquireStopped
+	}
+	return AcquireLockFailed
+}
+
+func (nopMetrics) Acquire(c context.Context, kind, queue, outcome string, latency time.Duration) {}
+func (nopMetrics) Retry(c context.Context, kind, queue string)                                   {}
+func (nopMetrics) Failure(c context.Context, kind, queue string)                                 {}
+func (nopMetrics) Overwrite(c context.Context, kind, queue string)                               {}
+func (nopMetrics) Hold(c context.Context, kind, queue string, duration time.Duration)            {}
@@ -0,0 +1,133 @@
+package locker
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
+)
+
+type recordingMetrics struct {
+	calls []string
+}
+
+func (m *recordingMetrics) Acquire(c context.Context, kind, queue, outcome string, latency time.Duration) {
+	m.calls = append(m.calls, "acquire:"+outcome)
+}
+
+func (m *recordingMetrics) Retry(c context.Context, kind, queue string) {
+	m.calls = append(m.calls, "retry")
+}
+
+func (m *recordingMetrics) Failure(c context.Context, kind, queue string) {
+	m.calls = append(m.calls, "failure")
+}
+
+func (m *recordingMetrics) Overwrite(c context.Context, kind, queue string) {
+	m.calls = append(m.calls, "overwrite")
+}
+
+func (m *recordingMetrics) Hold(c context.Context, kind, queue string, duration time.Duration) {
+	m.calls = append(m.calls, "hold")
+}
+
+func TestAcquireOutcome(t *testing.T) {
+	tests := []struct {
+		err     error
+		outcome string
+	}{
+		{nil, AcquireOK},
+		{ErrTaskExpired, AcquireExpired},
+		{ErrLockFailed, AcquireLockFailed},
+		{ErrTaskCancelled, AcquireStopped},
+		{ErrTaskPaused, AcquireStopped},
+		{ErrChainLimit, AcquireStopped},
+		{errors.New("datastore error"), AcquireLockFailed},
+	}
+	for _, test := range tests {
+		if outcome := acquireOutcome(test.err); outcome != test.outcome {
+			t.Errorf("expected %s for %v, got %s", test.outcome, test.err, outcome)
+		}
+	}
+}
+
+func TestHandleMetrics(t *testing.T) {
+	r, _ := instance.NewRequest("GET", "/", nil)
+	c := appengine.NewContext(r)
+	k := scheduleFoo(t, c, 44, 1)
+
+	m := new(recordingMetrics)
+	l, _ := NewLocker(MaxRetries(1), Metrics(m))
+	h := l.Handle(func(c context.Context, r *http.Request, key *datastore.Key, entity Lockable) error {
+		return errors.New("failed")
+	}, fooFactory)
+	for i := 0; i < 2; i++ {
+		h.ServeHTTP(httptest.NewRecorder(), newTaskRequest(t, k, 1))
+	}
+
+	expected := []string{"acquire:ok", "hold", "retry", "acquire:ok", "hold", "failure"}
+	if len(m.calls) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, m.calls)
+	}
+	for i := range expected {
+		if m.calls[i] != expected[i] {
+			t.Fatalf("expected %v, got %v", expected, m.calls)
+		}
+	}
+}
+
+func TestHandleMetricsOverwrite(t *testing.T) {
+	r, _ := instance.NewRequest("GET", "/", nil)
+	c := appengine.NewContext(r)
+
+	getTime = getTimeDefault
+	k := datastore.NewKey(c, "foo", "", 45, nil)
+	f := &Foo{
+		Value: "test",
+		Lock: Lock{
+			Timestamp: getTime().Add(-time.Hour),
+			RequestID: "dead",
+			Sequence:  1,
+			Path:      "/task/foo",
+			Status:    StatusRunning,
+		},
+	}
+	if _, err := datastore.Put(c, k, f); err != nil {
+		t.Fatal(err)
+	}
+
+	m := new(recordingMetrics)
+	l, _ := NewLocker(Metrics(m))
+	h := l.Handle(func(c context.Context, r *http.Request, key *datastore.Key, entity Lockable) error {
+		return nil
+	}, fooFactory)
+	h.ServeHTTP(httptest.NewRecorder(), newTaskRequest(t, k, 1))
+
+	expected := []string{"overwrite", "acquire:ok", "hold"}
+	if len(m.calls) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, m.calls)
+	}
+	for i := range expected {
+		if m.calls[i] != expected[i] {
+			t.Fatalf("expected %v, got %v", expected, m.calls)
+		}
+	}
+}
+
+func TestZeroLockerHooks(t *testing.T) {
+	l := &Locker{}
+	if l.log() == nil || l.metrics() == nil || l.tracing() == nil || l.alerts() == nil {
+		t.Fatal("expected default hooks for a zero locker")
+	}
+
+	m := &recordingMetrics{}
+	l.Metrics = m
+	if l.metrics() != m {
+		t.Error("expected configured metrics hook")
+	}
+}
@@ -0,0 +1,191 @@
+package locker
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/urlfetch"
+)
+
+type (
+	// Alert describes an exceptional lock situation that admins should
+	// be notified about
+	Alert struct {
+		// Reason is the reason for the alert (see the Reason* constants)
+		Reason string `json:"reason"`
+
+		// Key is the string representation of the entity key
+		Key string `json:"key"`
+
+		// EncodedKey is the URL-safe encoded entity key
+		EncodedKey string `json:"encoded_key"`
+
+		// Kind is the entity kind
+		Kind string `json:"kind"`
+
+		// Namespace is the entity namespace
+		Namespace string `json:"namespace"`
+
+		// KeyPath is the decoded key path from the root ancestor to the
+		// entity, each element in "kind,id" form
+		KeyPath []string `json:"key_path"`
+
+		// Status is the lock status
+		Status string `json:"status"`
+
+		// Sequence is the task sequence number of the lock
+		Sequence int `json:"sequence"`
+
+		// Retries is the number of retries of the lock
+		Retries int `json:"retries"`
+
+		// Holder is the request id that held the lock
+		Holder string `json:"holder"`
+
+		// LockedAt is the timestamp of the lock
+		LockedAt time.Time `json:"locked_at"`
+
+		// Path is the url of the task handler
+		Path string `json:"path"`
+
+		// Error is the last error returned by the task handler
+		Error string `json:"error,omitempty"`
+
+		// RequestID is the request id that raised the alert
+		RequestID string `json:"request_id"`
+
+		// Timestamp is the time the alert was raised
+		Timestamp time.Time `json:"timestamp"`
+
+		// Entity is the locked entity
+		Entity Lockable `json:"-"`
+
+		// Fields are the entity properties to include in the alert after
+		// the AlertFields allowlist and AlertRedact redaction are applied
+		Fields map[string]interface{} `json:"fields,omitempty"`
+
+		// Links are the urls of admin actions for the entity by name
+		Links map[string]string `json:"links,omitempty"`
+
+		// Digest summarises suppressed alerts (for ReasonDigest only)
+		Digest []*DigestEntry `json:"digest,omitempty"`
+	}
+
+	// Notifier sends alerts raised by the locker
+	Notifier interface {
+		Notify(c context.Context, alert *Alert) error
+	}
+
+	// WebhookNotifier sends alerts as a JSON payload to an HTTP endpoint
+	WebhookNotifier struct {
+		// URL is the address to POST alerts to
+		URL string
+
+		// Header is added to each request, e.g. for authorization
+		Header http.Header
+
+		// Client is the http client to use. If not set, the appengine
+		// urlfetch client for the request context is used.
+		Client *http.Client
+	}
+
+	// logNotifier writes alerts to a Logger
+	logNotifier struct {
+		logger Logger
+	}
+)
+
+const (
+	// ReasonFailure is the alert reason when a task fails permanently
+	ReasonFailure = "Permanent task failure"
+
+	// ReasonOverwrite is the alert reason when a lock is overwritten
+	ReasonOverwrite = "Lock overwrite"
+
+	// ReasonLimit is the alert reason when a chain fails because it
+	// exceeded the MaxSequence or MaxChainDuration
+	ReasonLimit = "Chain limit exceeded"
+)
+
+// Alerts sets the config setting for a locker
+func Alerts(notifier Notifier) func(*Locker) error {
+	return func(l *Locker) error {
+		l.Alerts = notifier
+		return nil
+	}
+}
+
+// alerts returns the notifier of the locker or the default if it isn't set,
+// e.g. for a Locker that wasn't created with NewLocker
+func (l *Locker) alerts() Notifier {
+	if l.Alerts == nil {
+		return new(EmailNotifier)
+	}
+	return l.Alerts
+}
+
+// NewLogNotifier creates a Notifier that only writes alerts to logger
+func NewLogNotifier(logger Logger) Notifier {
+	return &logNotifier{logger: logger}
+}
+
+// Notify implements Notifier
+func (n *WebhookNotifier) Notify(c context.Context, alert *Alert) error {
+	body, err := json.Marshal(alert)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", n.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for name, values := range n.Header {
+		req.Header[name] = values
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	client := n.Client
+	if client == nil {
+		client = urlfetch.Client(c)
+	}
+	resp, err := client.Do(req.WithContext(c))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+// Notify implements Notifier, each entry of a digest is logged separately
+func (n *logNotifier) Notify(c context.Context, alert *Alert) error {
+	fields := []Field{
+		{FieldKey, alert.Key},
+		{FieldKind, alert.Kind},
+		{FieldSequence, alert.Sequence},
+		{FieldRetries, alert.Retries},
+		{FieldRequestID, alert.RequestID},
+		{FieldHolder, alert.Holder},
+	}
+	if alert.Error != "" {
+		fields = append(fields, Field{FieldError, alert.Error})
+	}
+	n.logger.Error(c, alert.Reason, fields...)
+
+	for _, entry := range alert.Digest {
+		n.logger.Error(c, alert.Reason,
+			Field{FieldKind, entry.Kind},
+			Field{FieldReason, entry.Reason},
+			Field{FieldCount, entry.Count},
+			Field{FieldSince, entry.Since},
+			Field{FieldExamples, entry.Examples},
+		)
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package locker
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestWebhookNotifier(t *testing.T) {
+	var got map[string]interface{}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer ts.Close()
+
+	n := &WebhookNotifier{
+		URL:    ts.URL,
+		Header: http.Header{"Authorization": {"Bearer secret"}},
+		Client: ts.Client(),
+	}
+	alert := &Alert{
+		Reason:   ReasonFailure,
+		Key:      "/foo,1",
+		Kind:     "foo",
+		Sequence: 3,
+		Retries:  10,
+		Entity:   &Foo{Value: "test"},
+	}
+	if err := n.Notify(context.Background(), alert); err != nil {
+		t.Fatalf("notify failed %v", err)
+	}
+	if got["reason"] != ReasonFailure || got["kind"] != "foo" || got["sequence"] != float64(3) {
+		t.Errorf("unexpected payload %v", got)
+	}
+
+	n.Header = nil
+	if err := n.Notify(context.Background(), alert); err == nil {
+		t.Errorf("expected error for rejected webhook")
+	}
+}
+
+func TestLogNotifier(t *testing.T) {
+	buf := new(bytes.Buffer)
+	n := NewLogNotifier(NewSlogLogger(slog.New(slog.NewTextHandler(buf, nil))))
+
+	n.Notify(context.Background(), &Alert{Reason: ReasonFailure, Kind: "foo", Sequence: 2, Error: "it broke"})
+	if !strings.Contains(buf.String(), `error="it broke"`) {
+		t.Errorf("expected error in log output %s", buf.String())
+	}
+
+	buf.Reset()
+	n.Notify(context.Background(), &Alert{Reason: ReasonDigest, Digest: []*DigestEntry{
+		{Kind: "foo", Reason: ReasonFailure, Count: 3, Examples: []string{"a", "b"}},
+		{Kind: "bar", Reason: ReasonOverwrite, Count: 1},
+	}})
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("expected alert and 2 digest lines got %d: %s", len(lines), buf.String())
+	}
+	if !strings.Contains(lines[1], "kind=foo") || !strings.Contains(lines[1], "count=3") || !strings.Contains(lines[1], "examples=") {
+		t.Errorf("unexpected digest line %s", lines[1])
+	}
+}
@@ -0,0 +1,37 @@
+package locker
+
+import (
+	"net/http"
+	"net/url"
+)
+
+// PersistParams sets the config setting for a locker
+func PersistParams(l *Locker) error {
+	l.PersistParams = true
+	return nil
+}
+
+// storedParams returns the params stored on the lock
+func storedParams(lock *Lock) url.Values {
+	params, _ := url.ParseQuery(lock.Params)
+	return params
+}
+
+// restoreParams adds the params stored on the lock to the form of a task
+// request that was scheduled without them, e.g. by Retry or Reap
+func restoreParams(r *http.Request, lock *Lock) {
+	r.ParseForm()
+	if r.Form == nil {
+		r.Form = make(url.Values)
+	}
+	if r.PostForm == nil {
+		r.PostForm = make(url.Values)
+	}
+	for name, values := range storedParams(lock) {
+		if _, ok := r.PostForm[name]; ok {
+			continue
+		}
+		r.PostForm[name] = values
+		r.Form[name] = append(r.Form[name], values...)
+	}
+}
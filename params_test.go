@@ -0,0 +1,52 @@
+package locker
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/context"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
+)
+
+func TestRestoreParams(t *testing.T) {
+	r := httptest.NewRequest("POST", "/task/foo", strings.NewReader("b=2"))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	restoreParams(r, &Lock{Params: "a=1&b=3"})
+	if r.FormValue("a") != "1" {
+		t.Errorf("expected stored param to be restored, got %q", r.FormValue("a"))
+	}
+	if r.PostFormValue("b") != "2" {
+		t.Errorf("expected request param to be kept, got %q", r.PostFormValue("b"))
+	}
+}
+
+func TestPersistParams(t *testing.T) {
+	r, _ := instance.NewRequest("GET", "/", nil)
+	c := appengine.NewContext(r)
+
+	getTime = getTimeDefault
+	l, _ := NewLocker(PersistParams)
+	k := datastore.NewKey(c, "foo", "", 43, nil)
+	if err := l.Schedule(c, k, &Foo{Value: "test"}, "/task/foo", url.Values{"order": {"123"}}); err != nil {
+		t.Fatal(err)
+	}
+	if lock, _ := l.Inspect(c, k); lock.Params != "order=123" {
+		t.Fatalf("expected params to be stored %#v", lock)
+	}
+
+	// the task request has no body, as if it was re-scheduled by Retry
+	var order string
+	h := l.Handle(func(c context.Context, r *http.Request, key *datastore.Key, entity Lockable) error {
+		order = r.FormValue("order")
+		return l.Complete(c, key, entity)
+	}, fooFactory)
+	h.ServeHTTP(httptest.NewRecorder(), newTaskRequest(t, k, 1))
+	if order != "123" {
+		t.Errorf("expected handler to get the stored params, got %q", order)
+	}
+}
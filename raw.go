@@ -0,0 +1,62 @@
+package locker
+
+import (
+	"reflect"
+	"strings"
+
+	"google.golang.org/appengine/datastore"
+)
+
+type (
+	// RawEntity is a Lockable that can load and save any locked entity
+	// without knowing its type. The lock properties are available through
+	// the embedded Lock and all other properties are preserved as-is. It
+	// is used by the admin and control operations that work from a key.
+	RawEntity struct {
+		Lock
+		Properties datastore.PropertyList
+	}
+)
+
+// lockProperties is the set of property names used by the Lock struct. It
+// is built from the struct tags because SaveStruct omits empty slices.
+var lockProperties = func() map[string]bool {
+	t := reflect.TypeOf(Lock{})
+	names := make(map[string]bool, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := strings.Split(field.Tag.Get("datastore"), ",")[0]
+		if name == "" {
+			name = field.Name
+		}
+		if name != "-" {
+			names[name] = true
+		}
+	}
+	return names
+}()
+
+// Load implements datastore.PropertyLoadSaver
+func (e *RawEntity) Load(props []datastore.Property) error {
+	e.Properties = e.Properties[:0]
+	for _, p := range props {
+		if !lockProperties[p.Name] {
+			e.Properties = append(e.Properties, p)
+		}
+	}
+	err := datastore.LoadStruct(&e.Lock, props)
+	if _, ok := err.(*datastore.ErrFieldMismatch); ok {
+		// the non-lock properties are expected not to match
+		err = nil
+	}
+	return err
+}
+
+// Save implements datastore.PropertyLoadSaver
+func (e *RawEntity) Save() ([]datastore.Property, error) {
+	props, err := datastore.SaveStruct(&e.Lock)
+	if err != nil {
+		return nil, err
+	}
+	return append(props, e.Properties...), nil
+}
@@ -0,0 +1,41 @@
+package locker
+
+import (
+	"testing"
+
+	"google.golang.org/appengine/datastore"
+)
+
+func TestRawEntityRoundTrip(t *testing.T) {
+	f := &Foo{
+		Value: "test",
+		Lock: Lock{
+			Sequence:    3,
+			Compensate:  []string{"charge", "reserve"},
+			Compensated: []string{"notify"},
+		},
+	}
+	props, err := datastore.SaveStruct(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// loading and saving repeatedly mustn't duplicate the lock properties
+	for i := 0; i < 2; i++ {
+		entity := new(RawEntity)
+		if err := entity.Load(props); err != nil {
+			t.Fatal(err)
+		}
+		if props, err = entity.Save(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got := new(Foo)
+	if err := datastore.LoadStruct(got, props); err != nil {
+		t.Fatal(err)
+	}
+	if got.Value != "test" || got.Sequence != 3 || len(got.Compensate) != 2 || len(got.Compensated) != 1 {
+		t.Errorf("expected entity to round trip, got %#v", got)
+	}
+}
@@ -0,0 +1,75 @@
+package locker
+
+import (
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
+	"google.golang.org/appengine/taskqueue"
+)
+
+// Replay resets the chain of an entity to run again from a sequence, e.g.
+// after fixing a bug in the task handler. The retries are reset and a task
+// for the sequence is scheduled for the current Path of the lock, with the
+// stored params if replaying the current sequence (see PersistParams). It
+// returns ErrLockHeld if a task is currently holding the lock.
+//
+// A paused chain is resumed by the replay and a cancelled chain can't be
+// replayed (ErrTaskCancelled). The workflow step of earlier sequences isn't
+// known so a workflow can only be replayed from its current sequence and
+// not once it has been compensated (ErrNotRetryable), use Start to run it
+// again from the first step.
+func (l *Locker) Replay(c context.Context, key *datastore.Key, entity Lockable, fromSequence int) error {
+	return l.replay(c, key, entity, fromSequence, false)
+}
+
+// ForceReplay is like Replay but doesn't check if the lock is held. Any
+// task that is still running could overwrite the replayed chain.
+func (l *Locker) ForceReplay(c context.Context, key *datastore.Key, entity Lockable, fromSequence int) error {
+	return l.replay(c, key, entity, fromSequence, true)
+}
+
+func (l *Locker) replay(c context.Context, key *datastore.Key, entity Lockable, fromSequence int, force bool) error {
+	return datastore.RunInTransaction(c, func(tc context.Context) error {
+		if err := datastore.Get(tc, key, entity); err != nil {
+			return err
+		}
+		lock := entity.getLock()
+		if lock.Path == "" || fromSequence < 1 {
+			return ErrNotRetryable
+		}
+		if lock.Step != "" && (fromSequence != lock.Sequence || lock.Compensating || len(lock.Compensated) > 0) {
+			return ErrNotRetryable
+		}
+		if lock.Control == ControlCancelled {
+			return ErrTaskCancelled
+		}
+		running := lock.Status == StatusRunning || lock.Compensating
+		if !force && running && lock.RequestID != "" && lock.Timestamp.Add(l.LeaseTimeout).After(getTime()) {
+			return ErrLockHeld
+		}
+		lock.Control = ""
+
+		// the stored params only apply to the current sequence
+		params := ""
+		if fromSequence == lock.Sequence {
+			params = lock.Params
+		}
+		lock.Sequence = fromSequence - 1
+		l.startChain(lock)
+		lock.next(lock.Path)
+		lock.Params = params
+		lock.Compensating = false
+		if _, err := datastore.Put(tc, key, entity); err != nil {
+			return err
+		}
+		task := l.newTask(key, lock.Sequence, lock.Path, storedParams(lock))
+		l.tracing().Inject(tc, task.Header)
+		if _, err := taskqueue.Add(tc, task, l.lockQueue(tc, lock)); err != nil {
+			return err
+		}
+		// any dead-letter record is superseded by the new task
+		if err := datastore.Delete(tc, deadLetterKey(tc, key)); err != nil {
+			return err
+		}
+		return l.record(tc, key, EventReplay, lock.Sequence, lock.Retries)
+	}, nil)
+}
@@ -0,0 +1,89 @@
+package locker
+
+import (
+	"testing"
+
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
+)
+
+func TestReplay(t *testing.T) {
+	r, _ := instance.NewRequest("GET", "/", nil)
+	c := appengine.NewContext(r)
+	k := scheduleFoo(t, c, 40, 3)
+
+	l, _ := NewLocker()
+	if err := l.Aquire(c, k, new(Foo), 3); err != nil {
+		t.Fatal(err)
+	}
+
+	f := new(Foo)
+	if err := l.Replay(c, k, f, 1); err != ErrLockHeld {
+		t.Fatalf("expected replay of held lock to fail, got %v", err)
+	}
+	if err := l.ForceReplay(c, k, f, 1); err != nil {
+		t.Fatal(err)
+	}
+	if f.Sequence != 1 || f.RequestID != "" || f.Retries != 0 || f.Status != StatusPending {
+		t.Errorf("expected lock to be reset %#v", f.Lock)
+	}
+	if err := l.Aquire(c, k, new(Foo), 1); err != nil {
+		t.Errorf("expected replayed task to run, got %v", err)
+	}
+	if err := l.Replay(c, k, f, 0); err != ErrNotRetryable {
+		t.Errorf("expected invalid sequence to fail, got %v", err)
+	}
+}
+
+func TestReplayAfterFailure(t *testing.T) {
+	r, _ := instance.NewRequest("GET", "/", nil)
+	c := appengine.NewContext(r)
+	k := scheduleFoo(t, c, 157, 2)
+
+	l, _ := NewLocker()
+	f := new(Foo)
+	if err := l.Aquire(c, k, f, 2); err != nil {
+		t.Fatal(err)
+	}
+	l.fail(c, nil, k, f, ErrTaskFailed)
+
+	// a failed chain doesn't hold the lock
+	if err := l.Replay(c, k, new(Foo), 2); err != nil {
+		t.Fatalf("expected failed chain to replay, got %v", err)
+	}
+
+	// a paused chain is resumed by the replay, a cancelled one isn't replayed
+	if err := l.Pause(c, k); err != nil {
+		t.Fatal(err)
+	}
+	f = new(Foo)
+	if err := l.Replay(c, k, f, 1); err != nil || f.Control != "" {
+		t.Fatalf("expected paused chain to replay, got %v %#v", err, f.Lock)
+	}
+	if err := l.Cancel(c, k); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Replay(c, k, new(Foo), 1); err != ErrTaskCancelled {
+		t.Errorf("expected cancelled chain not to replay, got %v", err)
+	}
+}
+
+func TestReplayWorkflow(t *testing.T) {
+	r, _ := instance.NewRequest("GET", "/", nil)
+	c := appengine.NewContext(r)
+	k := scheduleFoo(t, c, 158, 3)
+
+	f := new(Foo)
+	datastore.Get(c, k, f)
+	f.Step = "email"
+	datastore.Put(c, k, f)
+
+	// the step of an earlier sequence isn't known
+	l, _ := NewLocker()
+	if err := l.Replay(c, k, new(Foo), 1); err != ErrNotRetryable {
+		t.Errorf("expected workflow replay of earlier sequence to fail, got %v", err)
+	}
+	if err := l.Replay(c, k, new(Foo), 3); err != nil {
+		t.Errorf("expected workflow replay of current sequence, got %v", err)
+	}
+}
@@ -0,0 +1,77 @@
+package locker
+
+import (
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
+	"google.golang.org/appengine/taskqueue"
+)
+
+type (
+	// RetryPolicy returns the delay before the next attempt of a task.
+	// The attempt is 1 for the first retry. For handler failures it is
+	// the Lock.Retries count after the failure, for lock contention the
+	// number of times the task has been re-scheduled.
+	RetryPolicy func(attempt int) time.Duration
+)
+
+// attemptHeader is the task header counting lock contention retries
+const attemptHeader = "X-Lock-Attempt"
+
+// Backoff sets the config setting for a locker
+func Backoff(policy RetryPolicy) func(*Locker) error {
+	return func(l *Locker) error {
+		l.Backoff = policy
+		return nil
+	}
+}
+
+// FixedBackoff is a RetryPolicy that always waits for the same delay
+func FixedBackoff(delay time.Duration) RetryPolicy {
+	return func(attempt int) time.Duration {
+		return delay
+	}
+}
+
+// ExponentialBackoff is a RetryPolicy that doubles the delay for each
+// attempt starting from base, up to max, with full jitter applied so
+// that competing tasks are spread out
+func ExponentialBackoff(base, max time.Duration) RetryPolicy {
+	return func(attempt int) time.Duration {
+		if attempt < 1 {
+			attempt = 1
+		}
+		d := max
+		if attempt < 32 {
+			if exp := base << uint(attempt-1); exp > 0 && exp < max {
+				d = exp
+			}
+		}
+		if d <= 0 {
+			return 0
+		}
+		return time.Duration(rand.Int63n(int64(d)) + 1)
+	}
+}
+
+// retryContention re-schedules the same sequence after the backoff delay
+// when the lock couldn't be aquired, instead of failing the request. After
+// MaxRetries attempts it returns ErrLockFailed so the request fails and the
+// task queue retry settings apply.
+func (l *Locker) retryContention(c context.Context, r *http.Request, key *datastore.Key, sequence int) error {
+	attempt, _ := strconv.Atoi(r.Header.Get(attemptHeader))
+	attempt++
+	if attempt > l.MaxRetries {
+		return ErrLockFailed
+	}
+
+	task := l.requeueTask(key, sequence, r)
+	task.Header.Set(attemptHeader, strconv.Itoa(attempt))
+	task.Delay = l.Backoff(attempt)
+	_, err := taskqueue.Add(c, task, l.queue(c))
+	return err
+}
@@ -0,0 +1,40 @@
+package locker
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestExponentialBackoff(t *testing.T) {
+	policy := ExponentialBackoff(time.Second, time.Minute)
+	for attempt, limit := range map[int]time.Duration{
+		1:   time.Second,
+		2:   2 * time.Second,
+		4:   8 * time.Second,
+		10:  time.Minute,
+		100: time.Minute,
+	} {
+		for i := 0; i < 100; i++ {
+			if d := policy(attempt); d <= 0 || d > limit {
+				t.Fatalf("attempt %d: expected delay up to %s, got %s", attempt, limit, d)
+			}
+		}
+	}
+}
+
+func TestFixedBackoff(t *testing.T) {
+	policy := FixedBackoff(30 * time.Second)
+	if d := policy(5); d != 30*time.Second {
+		t.Errorf("expected fixed delay, got %s", d)
+	}
+}
+
+func TestRetryContentionLimit(t *testing.T) {
+	l, _ := NewLocker(MaxRetries(3), Backoff(FixedBackoff(time.Second)))
+	r, _ := http.NewRequest("POST", "/task/foo", nil)
+	r.Header.Set(attemptHeader, "3")
+	if err := l.retryContention(nil, r, nil, 1); err != ErrLockFailed {
+		t.Errorf("expected contention retries to stop, got %v", err)
+	}
+}
@@ -0,0 +1,87 @@
+package locker
+
+import (
+	"net/http"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
+)
+
+type (
+	// waitForSignal is the TaskHandler result that parks the chain until
+	// a signal is delivered
+	waitForSignal struct {
+		name string
+	}
+)
+
+// SignalTimeout sets the config setting for a locker
+func SignalTimeout(timeout time.Duration) func(*Locker) error {
+	return func(l *Locker) error {
+		l.SignalTimeout = timeout
+		return nil
+	}
+}
+
+// FailOnSignalTimeout sets the config setting for a locker
+func FailOnSignalTimeout(l *Locker) error {
+	l.FailOnSignalTimeout = true
+	return nil
+}
+
+// WaitForSignal is returned by a TaskHandler to release the lock and park
+// the chain until the named signal is delivered with Signal, e.g. by a
+// webhook or an approval page. The next sequence is then handled by the
+// same url path with the signal payload in the Payload field of the lock.
+//
+// If a SignalTimeout is set then Reap will continue the chain without a
+// payload (or fail it with FailOnSignalTimeout) once the timeout passes.
+func WaitForSignal(name string) error {
+	return &waitForSignal{name}
+}
+
+func (e *waitForSignal) Error() string {
+	return "waiting for signal " + e.name
+}
+
+func (e *waitForSignal) suspend(c context.Context, l *Locker, r *http.Request, key *datastore.Key, entity Lockable) error {
+	lock := entity.getLock()
+	lock.next(r.URL.Path)
+	lock.Status = StatusWaiting
+	lock.Signal = e.name
+	if l.SignalTimeout > 0 {
+		lock.Wake = getTime().Add(l.SignalTimeout)
+	}
+	return datastore.RunInTransaction(c, func(tc context.Context) error {
+		if err := keepControl(tc, key, lock); err != nil {
+			return err
+		}
+		if _, err := datastore.Put(tc, key, entity); err != nil {
+			return err
+		}
+		return l.record(tc, key, EventWait, lock.Sequence, lock.Retries)
+	}, nil)
+}
+
+// Signal delivers the named signal to a chain that is waiting for it,
+// storing the payload on the entity and scheduling the next task. It
+// returns ErrNotWaiting if the chain isn't waiting for the signal.
+func (l *Locker) Signal(c context.Context, key *datastore.Key, name string, payload []byte) error {
+	return datastore.RunInTransaction(c, func(tc context.Context) error {
+		entity := new(RawEntity)
+		if err := datastore.Get(tc, key, entity); err != nil {
+			return err
+		}
+		if entity.Status != StatusWaiting || entity.Signal != name {
+			return ErrNotWaiting
+		}
+		entity.Signal = ""
+		entity.Payload = payload
+		entity.Wake = time.Time{}
+		if err := l.record(tc, key, EventSignal, entity.Sequence, entity.Retries); err != nil {
+			return err
+		}
+		return l.resume(tc, key, entity)
+	}, nil)
+}
@@ -0,0 +1,79 @@
+package locker
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
+)
+
+func TestWaitForSignal(t *testing.T) {
+	r, _ := instance.NewRequest("GET", "/", nil)
+	c := appengine.NewContext(r)
+	k := scheduleFoo(t, c, 36, 1)
+
+	l, _ := NewLocker()
+	var payload string
+	h := l.Handle(func(c context.Context, r *http.Request, key *datastore.Key, entity Lockable) error {
+		foo := entity.(*Foo)
+		if foo.Sequence == 1 {
+			return WaitForSignal("approved")
+		}
+		payload = string(foo.Payload)
+		return l.Complete(c, key, entity)
+	}, fooFactory)
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, newTaskRequest(t, k, 1))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected chain to wait, got %d", rec.Code)
+	}
+	if lock, _ := l.Inspect(c, k); lock.Status != StatusWaiting || lock.Signal != "approved" || lock.Sequence != 2 {
+		t.Fatalf("expected chain to wait for signal %#v", lock)
+	}
+
+	if err := l.Signal(c, k, "rejected", nil); err != ErrNotWaiting {
+		t.Errorf("expected unexpected signal to be refused, got %v", err)
+	}
+	if err := l.Signal(c, k, "approved", []byte("by admin")); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Signal(c, k, "approved", nil); err != ErrNotWaiting {
+		t.Errorf("expected repeated signal to be refused, got %v", err)
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, newTaskRequest(t, k, 2))
+	if rec.Code != http.StatusOK || payload != "by admin" {
+		t.Errorf("expected chain to continue with payload, got %d %q", rec.Code, payload)
+	}
+}
+
+func TestSignalTimeout(t *testing.T) {
+	r, _ := instance.NewRequest("GET", "/", nil)
+	c := appengine.NewContext(r)
+	k := scheduleFoo(t, c, 37, 1)
+
+	l, _ := NewLocker(SignalTimeout(time.Hour), FailOnSignalTimeout)
+	h := l.Handle(func(c context.Context, r *http.Request, key *datastore.Key, entity Lockable) error {
+		return WaitForSignal("approved")
+	}, fooFactory)
+	h.ServeHTTP(httptest.NewRecorder(), newTaskRequest(t, k, 1))
+
+	n := time.Now().Add(2 * time.Hour)
+	getTime = func() time.Time {
+		return n
+	}
+	defer func() { getTime = getTimeDefault }()
+	if n, err := l.Reap(c, "foo", 0); err != nil || n != 1 {
+		t.Fatalf("expected signal to time out, got %d %v", n, err)
+	}
+	lock, _ := l.Inspect(c, k)
+	if lock.Status != StatusFailed || lock.LastError != ErrSignalTimeout.Error() {
+		t.Errorf("expected chain to fail on timeout %#v", lock)
+	}
+}
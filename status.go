@@ -0,0 +1,21 @@
+package locker
+
+import (
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
+)
+
+// StatusQuery returns a query for entities of a kind with the given
+// lock status. Use it directly to page through large result sets or
+// to add further filters (which may need a composite index).
+func StatusQuery(kind, status string) *datastore.Query {
+	return datastore.NewQuery(kind).Filter("lock_status =", status)
+}
+
+// KeysByStatus returns the keys of all entities of a kind with the given
+// lock status, e.g. to find all failed chains:
+//
+//	keys, err := l.KeysByStatus(c, "order", locker.StatusFailed)
+func (l *Locker) KeysByStatus(c context.Context, kind, status string) ([]*datastore.Key, error) {
+	return StatusQuery(kind, status).KeysOnly().GetAll(c, nil)
+}
@@ -0,0 +1,45 @@
+package locker
+
+import (
+	"testing"
+
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
+)
+
+func TestKeysByStatus(t *testing.T) {
+	r, _ := instance.NewRequest("GET", "/", nil)
+	r.Header.Set("X-AppEngine-Request-Log-Id", "status")
+	c := appengine.NewContext(r)
+
+	getTime = getTimeDefault
+	l, _ := NewLocker()
+
+	k := datastore.NewKey(c, "status", "", 1, nil)
+	f := &Foo{Value: "test"}
+	if err := l.Schedule(c, k, f, "/task", nil); err != nil {
+		t.Fatal(err)
+	}
+	if f.Status != StatusPending {
+		t.Errorf("expected pending status, got %q", f.Status)
+	}
+
+	if err := l.Aquire(c, k, f, 1); err != nil {
+		t.Fatalf("failed to lock %v", err)
+	}
+	keys, err := l.KeysByStatus(c, "status", StatusRunning)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 1 || !keys[0].Equal(k) {
+		t.Errorf("expected running entity, got %v", keys)
+	}
+
+	if err := l.Complete(c, k, f); err != nil {
+		t.Fatal(err)
+	}
+	keys, _ = l.KeysByStatus(c, "status", StatusCompleted)
+	if len(keys) != 1 {
+		t.Errorf("expected completed entity, got %v", keys)
+	}
+}
@@ -1,10 +1,11 @@
 package locker
 
 import (
+	"errors"
 	"strconv"
+	"strings"
 	"time"
 
-	"math/rand"
 	"net/http"
 	"net/url"
 
@@ -33,17 +34,35 @@ func (l *Locker) Parse(c context.Context, r *http.Request) (*datastore.Key, int,
 func (l *Locker) NewTask(key *datastore.Key, entity Lockable, path string, params url.Values) *taskqueue.Task {
 	// prepare the lock entries
 	lock := entity.getLock()
-	lock.Timestamp = getTime()
-	lock.RequestID = ""
-	lock.Retries = 0
-	lock.Sequence++
+	if lock.ended() {
+		l.startChain(lock)
+	}
+	lock.next(path)
+	if l.PersistParams {
+		lock.Params = params.Encode()
+	}
+
+	return l.newTask(key, lock.Sequence, path, params)
+}
+
+// startChain records the start time and limits of a new chain
+func (l *Locker) startChain(lock *Lock) {
+	lock.Started = getTime()
+	lock.Limit = l.MaxSequence
+	lock.Deadline = time.Time{}
+	if l.MaxChainDuration > 0 {
+		lock.Deadline = lock.Started.Add(l.MaxChainDuration)
+	}
+}
 
+// newTask creates a task for a specific sequence of the entity
+func (l *Locker) newTask(key *datastore.Key, sequence int, path string, params url.Values) *taskqueue.Task {
 	json, _ := key.MarshalJSON()
 
 	// set task headers so that we can retrieve the matching entity
 	// and check that the executing task is the one we're expecting
 	task := taskqueue.NewPOSTTask(path, params)
-	task.Header.Set("X-Lock-Seq", strconv.Itoa(lock.Sequence))
+	task.Header.Set("X-Lock-Seq", strconv.Itoa(sequence))
 	task.Header.Set("X-Lock-Key", string(json))
 
 	if l.Host != "" {
@@ -53,30 +72,98 @@ func (l *Locker) NewTask(key *datastore.Key, entity Lockable, path string, param
 	return task
 }
 
-// Schedule schedules a task with lock
-func (l *Locker) Schedule(c context.Context, key *datastore.Key, entity Lockable, path string, params url.Values) error {
-	task := l.NewTask(key, entity, path, params)
-
-	// Use same queue that we started on if defined, otherwise use configured default
+// queue returns the queue to schedule tasks on. Use same queue that we
+// started on if defined, otherwise use configured default
+func (l *Locker) queue(c context.Context) string {
 	queue, ok := QueueFromContext(c)
 	if !ok {
 		queue = l.DefaultQueue
 	}
+	return queue
+}
+
+// lockQueue returns the queue that the task for the current sequence of
+// a lock was scheduled on, so that a task re-scheduled outside of a task
+// handler, e.g. by an admin, stays on the same queue
+func (l *Locker) lockQueue(c context.Context, lock *Lock) string {
+	if lock.Queue != "" {
+		return lock.Queue
+	}
+	return l.queue(c)
+}
+
+// requeueTask creates a new task for the same sequence as the executing
+// task request r with the same path, params and headers
+func (l *Locker) requeueTask(key *datastore.Key, sequence int, r *http.Request) *taskqueue.Task {
+	r.ParseForm()
+	task := l.newTask(key, sequence, r.URL.Path, r.PostForm)
+	for name, values := range forwardHeaders(r.Header) {
+		if task.Header.Get(name) == "" {
+			task.Header[name] = values
+		}
+	}
+	return task
+}
+
+// forwardHeaders returns the task headers that should be carried over
+// to a re-scheduled task, excluding those set by the platform
+func forwardHeaders(header http.Header) http.Header {
+	h := make(http.Header)
+	for name, values := range header {
+		if strings.HasPrefix(name, "X-Appengine-") || name == "Content-Length" || name == "Content-Type" {
+			continue
+		}
+		h[name] = values
+	}
+	return h
+}
+
+// Schedule schedules a task with lock. A task that is delayed with the
+// Delay or ETA options leaves the chain waiting with the Wake time set.
+// If the next task would exceed the chain limits the chain is failed and
+// ErrChainLimit returned instead.
+func (l *Locker) Schedule(c context.Context, key *datastore.Key, entity Lockable, path string, params url.Values, options ...ScheduleOption) (err error) {
+	c, end := l.tracing().Start(c, "locker.schedule")
+	defer func() { end(err) }()
+
+	// the chain is failed before the lock is changed so that the failure
+	// is recorded and alerted even if the handler ignores the error
+	if entity.getLock().nextExceeded() {
+		l.fail(c, nil, key, entity, ErrChainLimit)
+		return ErrChainLimit
+	}
+	task := l.NewTask(key, entity, path, params)
+	l.tracing().Inject(c, task.Header)
+	for _, option := range options {
+		option(task)
+	}
+	if wake := taskWake(task); !wake.IsZero() {
+		lock := entity.getLock()
+		lock.Status = StatusWaiting
+		lock.Wake = wake
+	}
+
+	queue := l.queue(c)
+	entity.getLock().Queue = queue
 
 	// write the datastore entity and schedule the task within a
 	// transaction to guarantees that both happen and the entity
 	// will be committed to the datastore when the task executes but
 	// the task won't be scheduled if our entity update fails
-	err := datastore.RunInTransaction(c, func(tc context.Context) error {
+	err = datastore.RunInTransaction(c, func(tc context.Context) error {
 		// TODO: check if entity already exists and handle accordingly
 		// don't overwrite if already locked for processing
+		if err := keepControl(tc, key, entity.getLock()); err != nil {
+			return err
+		}
 		if _, err := datastore.Put(tc, key, entity); err != nil {
 			return err
 		}
 		if _, err := taskqueue.Add(tc, task, queue); err != nil {
 			return err
 		}
-		return nil
+		lock := entity.getLock()
+		return l.record(tc, key, EventSchedule, lock.Sequence, lock.Retries)
 	}, &datastore.TransactionOptions{XG: false, Attempts: 3})
 
 	return err
@@ -87,6 +174,18 @@ func (l *Locker) Schedule(c context.Context, key *datastore.Key, entity Lockable
 // and return nil, otherwise it will return an error to indicate
 // the reason for failure.
 func (l *Locker) Aquire(c context.Context, key *datastore.Key, entity Lockable, sequence int) error {
+	start := time.Now()
+	err := l.aquire(c, key, entity, sequence)
+	if err == nil && entity.getLock().exceeded() {
+		l.fail(c, nil, key, entity, ErrChainLimit)
+		err = ErrChainLimit
+	}
+	queue, _ := QueueFromContext(c)
+	l.metrics().Acquire(c, key.Kind(), queue, acquireOutcome(err), time.Since(start))
+	return err
+}
+
+func (l *Locker) aquire(c context.Context, key *datastore.Key, entity Lockable, sequence int) error {
 	requestID := appengine.RequestID(c)
 	lock := new(Lock)
 	success := false
@@ -105,12 +204,27 @@ func (l *Locker) Aquire(c context.Context, key *datastore.Key, entity Lockable,
 		// we got the entity successfully, check if it's locked
 		// and try to claim the lease if it isn't
 		lock = entity.getLock()
+		if lock.Control != "" {
+			return nil
+		}
 		if lock.RequestID == "" && lock.Sequence == sequence {
 			lock.Timestamp = getTime()
 			lock.RequestID = requestID
+			// a chain being compensated stays failed
+			if !lock.Compensating {
+				lock.Status = StatusRunning
+			}
+			lock.Wake = time.Time{}
 			if _, err := datastore.Put(tc, key, entity); err != nil {
 				return err
 			}
+			eventType := EventAcquire
+			if lock.Retries > 0 {
+				eventType = EventRetry
+			}
+			if err := l.record(tc, key, eventType, lock.Sequence, lock.Retries); err != nil {
+				return err
+			}
 			success = true
 			return nil
 		}
@@ -132,13 +246,23 @@ func (l *Locker) Aquire(c context.Context, key *datastore.Key, entity Lockable,
 		return nil
 	}
 
+	// the chain has been stopped, drop the task
+	switch lock.Control {
+	case ControlCancelled:
+		return ErrTaskCancelled
+	case ControlPaused:
+		return ErrTaskPaused
+	}
+
 	// If there wasn't any error but we weren't successful then a lock is
 	// already in place. We're most likely here because a duplicate task has
 	// been scheduled or executed so we need to examine the lock itself
-	log.Debugf(c, "lock %v %d %d %s", lock.Timestamp, lock.Sequence, lock.Retries, lock.RequestID)
+	l.log().Debug(c, "lock held", append(lockFields(c, key, lock.Sequence, lock.Retries),
+		Field{FieldLockedAt, lock.Timestamp}, Field{FieldHolder, lock.RequestID})...)
 
 	// if the lock sequence is already past this task so it should be dropped
 	if lock.Sequence > sequence {
+		l.recordOutside(c, key, EventExpire, sequence, lock.Retries)
 		return ErrTaskExpired
 	}
 
@@ -164,17 +288,29 @@ func (l *Locker) Aquire(c context.Context, key *datastore.Key, entity Lockable,
 }
 
 // Complete marks a task as completed
-func (l *Locker) Complete(c context.Context, key *datastore.Key, entity Lockable) error {
+func (l *Locker) Complete(c context.Context, key *datastore.Key, entity Lockable) (err error) {
+	c, end := l.tracing().Start(c, "locker.complete")
+	defer func() { end(err) }()
+
 	// prepare the lock entries
 	lock := entity.getLock()
 	lock.Complete()
 
 	// TODO: do we need to re-fetch the entity to guarantee freshness?
-	err := datastore.RunInTransaction(c, func(tc context.Context) error {
+	err = datastore.RunInTransaction(c, func(tc context.Context) error {
+		// a child chain lets the parent know it's done
+		if lock.Parent != nil {
+			if err := l.notifyParent(tc, key, lock, false); err != nil {
+				return err
+			}
+		}
+		if err := keepControl(tc, key, lock); err != nil {
+			return err
+		}
 		if _, err := datastore.Put(tc, key, entity); err != nil {
 			return err
 		}
-		return nil
+		return l.record(tc, key, EventComplete, lock.Sequence, lock.Retries)
 	}, nil)
 
 	return err
@@ -182,42 +318,134 @@ func (l *Locker) Complete(c context.Context, key *datastore.Key, entity Lockable
 
 // clearLock clears the current lease, it should be called at the end of every task
 // execution if things fail, to try and prevent unecessary locks and to count the
-// number of retries
-func (l *Locker) clearLock(c context.Context, key *datastore.Key, entity Lockable) error {
+// number of retries. The cause is the error returned by the task handler for the
+// task request r.
+func (l *Locker) clearLock(c context.Context, r *http.Request, key *datastore.Key, entity Lockable, cause error) error {
+	return l.releaseLock(c, r, key, entity, cause, false, 0)
+}
+
+// deferLock clears the current lease like clearLock but also schedules the
+// retry of the same sequence after the delay rather than relying on the
+// task queue retry
+func (l *Locker) deferLock(c context.Context, r *http.Request, key *datastore.Key, entity Lockable, cause error, delay time.Duration) error {
+	return l.releaseLock(c, r, key, entity, cause, true, delay)
+}
+
+func (l *Locker) releaseLock(c context.Context, r *http.Request, key *datastore.Key, entity Lockable, cause error, requeue bool, delay time.Duration) error {
+	queue, _ := QueueFromContext(c)
 	lock := entity.getLock()
 	if lock.Retries == l.MaxRetries {
-		if l.AlertOnFailure {
-			if err := alertAdmins(c, key, entity, "Permanent task failure"); err != nil {
-				log.Errorf(c, "failed to send alert email for permanent task failure: %v", err)
-			}
-		}
-		return ErrTaskFailed
+		return l.fail(c, r, key, entity, cause)
 	}
 	err := datastore.RunInTransaction(c, func(tc context.Context) error {
 		if err := datastore.Get(tc, key, entity); err != nil {
-			log.Debugf(c, "clearLock get %v", err)
+			l.log().Debug(c, "clearLock get failed", withError(lockFields(c, key, lock.Sequence, lock.Retries), err)...)
 			return err
 		}
 		lock := entity.getLock()
 		lock.Timestamp = getTime()
 		lock.RequestID = ""
 		lock.Retries++
+		if !lock.Compensating {
+			lock.Status = StatusPending
+		}
+		lock.setError(cause)
 		if _, err := datastore.Put(tc, key, entity); err != nil {
-			log.Debugf(c, "clearLock put %v", err)
+			l.log().Debug(c, "clearLock put failed", withError(lockFields(c, key, lock.Sequence, lock.Retries), err)...)
 			return err
 		}
-		return nil
+		if requeue {
+			task := l.requeueTask(key, lock.Sequence, r)
+			task.Delay = delay
+			if _, err := taskqueue.Add(tc, task, l.queue(c)); err != nil {
+				return err
+			}
+		}
+		return l.record(tc, key, EventRelease, lock.Sequence, lock.Retries)
 	}, nil)
+	if err == nil {
+		l.metrics().Retry(c, key.Kind(), queue)
+	}
 	return err
 }
 
+// fail handles a task that has failed permanently. The error that caused
+// the failure is recorded on the lock, the task request r captured in the
+// dead-letter store, the parent of a child chain notified, compensation
+// of a workflow started and admins alerted if configured. It always
+// returns ErrTaskFailed so the task will be abandoned.
+func (l *Locker) fail(c context.Context, r *http.Request, key *datastore.Key, entity Lockable, cause error) error {
+	err := datastore.RunInTransaction(c, func(tc context.Context) error {
+		if err := datastore.Get(tc, key, entity); err != nil {
+			return err
+		}
+		return l.failLock(tc, r, key, entity, cause)
+	}, nil)
+	if err != nil {
+		lock := entity.getLock()
+		l.log().Error(c, "failed to record permanent task failure", withError(lockFields(c, key, lock.Sequence, lock.Retries), err)...)
+	}
+	l.failed(c, key, entity, cause)
+	return ErrTaskFailed
+}
+
+// failLock marks the chain of an entity as failed with the cause. It must
+// be called in a transaction.
+func (l *Locker) failLock(c context.Context, r *http.Request, key *datastore.Key, entity Lockable, cause error) error {
+	lock := entity.getLock()
+	if lock.Parent != nil {
+		if err := l.notifyParent(c, key, lock, true); err != nil {
+			return err
+		}
+	}
+	lock.Timestamp = getTime()
+	lock.RequestID = ""
+	lock.Status = StatusFailed
+	lock.setError(cause)
+
+	// the failure is recorded against the sequence that failed before any
+	// compensation moves the chain on
+	if r != nil {
+		if _, err := datastore.Put(c, deadLetterKey(c, key), newDeadLetter(key, r, lock)); err != nil {
+			return err
+		}
+	}
+	if err := l.record(c, key, EventFail, lock.Sequence, lock.Retries); err != nil {
+		return err
+	}
+
+	// undo the completed steps of a workflow
+	if len(lock.Compensate) > 0 {
+		if err := l.compensate(c, key, lock); err != nil {
+			return err
+		}
+	} else {
+		lock.Compensating = false
+	}
+	_, err := datastore.Put(c, key, entity)
+	return err
+}
+
+// failed counts the failure of the chain of an entity and alerts admins
+// if configured, it is called once the failure has been recorded
+func (l *Locker) failed(c context.Context, key *datastore.Key, entity Lockable, cause error) {
+	queue, _ := QueueFromContext(c)
+	l.metrics().Failure(c, key.Kind(), queue)
+
+	// a chain that exceeds its limits is likely a bug so always alert
+	if errors.Is(cause, ErrChainLimit) {
+		l.alert(c, key, entity, ReasonLimit, cause)
+	} else if l.AlertOnFailure {
+		l.alert(c, key, entity, ReasonFailure, cause)
+	}
+}
+
 // overwrite the current lock
 func (l *Locker) overwriteLock(c context.Context, key *datastore.Key, entity Lockable, requestID string) error {
-	log.Debugf(c, "overwriteLock %s %s", key.String(), requestID)
+	lock := entity.getLock()
+	l.log().Debug(c, "overwriteLock", append(lockFields(c, key, lock.Sequence, lock.Retries), Field{FieldHolder, lock.RequestID})...)
 	if l.AlertOnOverwrite {
-		if err := alertAdmins(c, key, entity, "Lock overwrite"); err != nil {
-			log.Errorf(c, "failed to send alert email for lock overwrite: %v", err)
-		}
+		l.alert(c, key, entity, ReasonOverwrite, nil)
 	}
 	err := datastore.RunInTransaction(c, func(tc context.Context) error {
 		if err := datastore.Get(tc, key, entity); err != nil {
@@ -226,11 +454,18 @@ func (l *Locker) overwriteLock(c context.Context, key *datastore.Key, entity Loc
 		lock := entity.getLock()
 		lock.Timestamp = getTime()
 		lock.RequestID = requestID
+		if !lock.Compensating {
+			lock.Status = StatusRunning
+		}
 		if _, err := datastore.Put(tc, key, entity); err != nil {
 			return err
 		}
-		return nil
+		return l.record(tc, key, EventOverwrite, lock.Sequence, lock.Retries)
 	}, nil)
+	if err == nil {
+		queue, _ := QueueFromContext(c)
+		l.metrics().Overwrite(c, key.Kind(), queue)
+	}
 	return err
 }
 
@@ -244,24 +479,19 @@ func (l *Locker) previousRequestEnded(c context.Context, requestID string) bool
 	if err == log.Done {
 		// no record found so it hasn't ended
 		if l.LogVerbose {
-			log.Warningf(c, "no log found for previous request %s", requestID)
+			l.log().Warning(c, "no log found for previous request", Field{FieldHolder, requestID})
 		}
 		return false
 	}
 	if err != nil {
 		// Managed VMs do not have access to the logservice API
 		if l.LogVerbose {
-			log.Warningf(c, "err getting log for previous request %s %v", requestID, err)
+			l.log().Warning(c, "error getting log for previous request", Field{FieldHolder, requestID}, Field{FieldError, err.Error()})
 		}
 		return false
 	}
 	if l.LogVerbose {
-		log.Debugf(c, "found previous request log %v", record)
+		l.log().Debug(c, "found previous request log", Field{FieldHolder, requestID}, Field{"finished", record.Finished})
 	}
 	return record.Finished
 }
-
-func randomDelay() {
-	d := time.Duration(rand.Int63n(4)+1) * time.Second
-	time.Sleep(d)
-}
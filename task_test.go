@@ -1,6 +1,7 @@
 package locker
 
 import (
+	"errors"
 	"testing"
 	"time"
 
@@ -91,3 +92,47 @@ func TestGetLockAlreadyLocked(t *testing.T) {
 	datastore.Get(c, k, f)
 	t.Logf("%v", f)
 }
+
+func TestClearLockRecordsError(t *testing.T) {
+	r, _ := instance.NewRequest("GET", "/", nil)
+	c := appengine.NewContext(r)
+
+	getTime = getTimeDefault
+	f := &Foo{
+		Value: "test",
+		Lock: Lock{
+			Timestamp: getTime(),
+			RequestID: "locked",
+			Sequence:  3,
+		},
+	}
+
+	k := datastore.NewKey(c, "foo", "", 3, nil)
+	if _, err := datastore.Put(c, k, f); err != nil {
+		t.Fatal(err)
+	}
+
+	l, _ := NewLocker(MaxRetries(1))
+	if err := l.clearLock(c, nil, k, f, errors.New("partner api unavailable")); err != nil {
+		t.Fatalf("failed to clear lock %v", err)
+	}
+
+	lock, err := l.Inspect(c, k)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lock.RequestID != "" || lock.Retries != 1 {
+		t.Errorf("expected lock to be cleared %#v", lock)
+	}
+	if lock.LastError != "partner api unavailable" || lock.ErrorSequence != 3 {
+		t.Errorf("expected error to be recorded %#v", lock)
+	}
+
+	if err := l.clearLock(c, nil, k, f, errors.New("still unavailable")); err != ErrTaskFailed {
+		t.Errorf("expected permanent failure, got %v", err)
+	}
+	lock, _ = l.Inspect(c, k)
+	if lock.LastError != "still unavailable" {
+		t.Errorf("expected final error to be recorded %#v", lock)
+	}
+}
@@ -0,0 +1,61 @@
+package locker
+
+import (
+	htmltemplate "html/template"
+	texttemplate "text/template"
+)
+
+// alertText is the default plain text alert template
+const alertText = `{{.Reason}}
+{{if .Digest}}
+{{range .Digest}}{{.Kind}}: {{.Reason}} x {{.Count}} since {{.Since}}
+{{range .Examples}}  {{.}}
+{{end}}{{end}}{{else}}
+Key:        {{.Key}}
+Namespace:  {{.Namespace}}
+Path:       {{range $i, $p := .KeyPath}}{{if $i}} > {{end}}{{$p}}{{end}}
+Handler:    {{.Path}}
+Status:     {{.Status}}
+Sequence:   {{.Sequence}}
+Retries:    {{.Retries}}
+Holder:     {{.Holder}}
+Locked at:  {{.LockedAt}}
+Last error: {{.Error}}
+{{if .Fields}}
+Fields:
+{{range $name, $value := .Fields}}  {{$name}}: {{$value}}
+{{end}}{{end}}{{if .Links}}
+Actions:
+{{range $name, $url := .Links}}  {{$name}}: {{$url}}
+{{end}}{{end}}{{end}}`
+
+// alertHTML is the default HTML alert template
+const alertHTML = `<h2>{{.Reason}}</h2>
+{{if .Digest}}<table>
+<tr><th>Kind</th><th>Reason</th><th>Count</th><th>Since</th><th>Examples</th></tr>
+{{range .Digest}}<tr><td>{{.Kind}}</td><td>{{.Reason}}</td><td>{{.Count}}</td><td>{{.Since}}</td><td>{{range .Examples}}{{.}}<br>{{end}}</td></tr>
+{{end}}</table>{{else}}<table>
+<tr><th>Key</th><td>{{.Key}}</td></tr>
+<tr><th>Namespace</th><td>{{.Namespace}}</td></tr>
+<tr><th>Path</th><td>{{range $i, $p := .KeyPath}}{{if $i}} &gt; {{end}}{{$p}}{{end}}</td></tr>
+<tr><th>Handler</th><td>{{.Path}}</td></tr>
+<tr><th>Status</th><td>{{.Status}}</td></tr>
+<tr><th>Sequence</th><td>{{.Sequence}}</td></tr>
+<tr><th>Retries</th><td>{{.Retries}}</td></tr>
+<tr><th>Holder</th><td>{{.Holder}}</td></tr>
+<tr><th>Locked at</th><td>{{.LockedAt}}</td></tr>
+<tr><th>Last error</th><td>{{.Error}}</td></tr>
+</table>
+{{if .Fields}}<h3>Fields</h3>
+<table>
+{{range $name, $value := .Fields}}<tr><th>{{$name}}</th><td>{{$value}}</td></tr>
+{{end}}</table>{{end}}
+{{if .Links}}<p>{{range $name, $url := .Links}}<a href="{{$url}}">{{$name}}</a> {{end}}</p>{{end}}{{end}}`
+
+var (
+	// DefaultTextTemplate is the template used for plain text alerts
+	DefaultTextTemplate = texttemplate.Must(texttemplate.New("alert").Parse(alertText))
+
+	// DefaultHTMLTemplate is the template used for HTML alerts
+	DefaultHTMLTemplate = htmltemplate.Must(htmltemplate.New("alert").Parse(alertHTML))
+)
@@ -0,0 +1,171 @@
+package locker
+
+import (
+	"net/http"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
+)
+
+type (
+	// ThrottledNotifier wraps a Notifier to prevent alert storms. Only one
+	// alert per entity kind and reason is sent within each Window, others
+	// are counted and summarised by SendDigest. The throttle state is kept
+	// in the datastore so it applies across instances.
+	ThrottledNotifier struct {
+		// Notifier is used to send alerts and digests
+		Notifier Notifier
+
+		// Window is the minimum time between alerts for the same kind
+		// and reason
+		Window time.Duration
+
+		// Examples is the maximum number of example keys to keep for
+		// suppressed alerts
+		Examples int
+	}
+
+	// DigestEntry summarises the alerts suppressed for a kind and reason
+	DigestEntry struct {
+		Kind     string    `json:"kind"`
+		Reason   string    `json:"reason"`
+		Count    int       `json:"count"`
+		Since    time.Time `json:"since"`
+		Examples []string  `json:"examples"`
+	}
+
+	// alertThrottle is the persisted throttle state for a kind and reason
+	alertThrottle struct {
+		Kind       string    `datastore:"kind,noindex"`
+		Reason     string    `datastore:"reason,noindex"`
+		Sent       time.Time `datastore:"sent,noindex"`
+		Suppressed int       `datastore:"suppressed"`
+		Since      time.Time `datastore:"since,noindex"`
+		Examples   []string  `datastore:"examples,noindex"`
+	}
+)
+
+// ReasonDigest is the alert reason for a digest of suppressed alerts
+const ReasonDigest = "Suppressed alert digest"
+
+// throttleKind is the datastore kind used for alert throttle state
+const throttleKind = "_lock_alert"
+
+// NewThrottledNotifier creates a ThrottledNotifier sending at most one
+// alert per kind and reason in each window
+func NewThrottledNotifier(notifier Notifier, window time.Duration) *ThrottledNotifier {
+	return &ThrottledNotifier{
+		Notifier: notifier,
+		Window:   window,
+		Examples: 10,
+	}
+}
+
+// Notify implements Notifier
+func (t *ThrottledNotifier) Notify(c context.Context, alert *Alert) error {
+	key := datastore.NewKey(c, throttleKind, alert.Kind+"/"+alert.Reason, 0, nil)
+	send := false
+	err := datastore.RunInTransaction(c, func(tc context.Context) error {
+		// reset flag here in case of transaction retries
+		send = false
+
+		state := new(alertThrottle)
+		if err := datastore.Get(tc, key, state); err != nil && err != datastore.ErrNoSuchEntity {
+			return err
+		}
+		state.Kind = alert.Kind
+		state.Reason = alert.Reason
+
+		now := getTime()
+		if state.Sent.Add(t.Window).After(now) {
+			if state.Suppressed == 0 {
+				state.Since = now
+			}
+			state.Suppressed++
+			if len(state.Examples) < t.Examples {
+				state.Examples = append(state.Examples, alert.Key)
+			}
+		} else {
+			state.Sent = now
+			send = true
+		}
+		_, err := datastore.Put(tc, key, state)
+		return err
+	}, nil)
+	if err != nil || !send {
+		return err
+	}
+	return t.Notifier.Notify(c, alert)
+}
+
+// SendDigest sends a single alert summarising all suppressed alerts
+// and resets the suppressed counts. It should be called periodically,
+// see DigestHandler.
+func (t *ThrottledNotifier) SendDigest(c context.Context) error {
+	var states []*alertThrottle
+	keys, err := datastore.NewQuery(throttleKind).Filter("suppressed >", 0).GetAll(c, &states)
+	if err != nil || len(keys) == 0 {
+		return err
+	}
+
+	alert := &Alert{
+		Reason:    ReasonDigest,
+		RequestID: appengine.RequestID(c),
+		Timestamp: getTime(),
+	}
+	for _, state := range states {
+		alert.Digest = append(alert.Digest, &DigestEntry{
+			Kind:     state.Kind,
+			Reason:   state.Reason,
+			Count:    state.Suppressed,
+			Since:    state.Since,
+			Examples: state.Examples,
+		})
+	}
+	if err := t.Notifier.Notify(c, alert); err != nil {
+		return err
+	}
+
+	// subtract what was reported so that alerts suppressed while the
+	// digest was being sent will be included in the next one
+	for i, key := range keys {
+		reported := states[i].Suppressed
+		err := datastore.RunInTransaction(c, func(tc context.Context) error {
+			state := new(alertThrottle)
+			if err := datastore.Get(tc, key, state); err != nil {
+				return err
+			}
+			state.Suppressed -= reported
+			if state.Suppressed <= 0 {
+				state.Suppressed = 0
+				state.Examples = nil
+			}
+			_, err := datastore.Put(tc, key, state)
+			return err
+		}, nil)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DigestHandler returns an http.Handler that sends the digest, intended
+// to be called by cron:
+//
+//	cron:
+//	- description: locker alert digest
+//	  url: /_locker/digest
+//	  schedule: every 1 hours
+func (t *ThrottledNotifier) DigestHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c := appengine.NewContext(r)
+		if err := t.SendDigest(c); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+}
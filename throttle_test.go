@@ -0,0 +1,47 @@
+package locker
+
+import (
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/appengine"
+)
+
+type recordingNotifier struct {
+	alerts []*Alert
+}
+
+func (n *recordingNotifier) Notify(c context.Context, alert *Alert) error {
+	n.alerts = append(n.alerts, alert)
+	return nil
+}
+
+func TestThrottledNotifier(t *testing.T) {
+	r, _ := instance.NewRequest("GET", "/", nil)
+	c := appengine.NewContext(r)
+
+	getTime = getTimeDefault
+	rec := new(recordingNotifier)
+	n := NewThrottledNotifier(rec, time.Hour)
+
+	for _, key := range []string{"/foo,1", "/foo,2", "/foo,3"} {
+		if err := n.Notify(c, &Alert{Reason: ReasonFailure, Kind: "foo", Key: key}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(rec.alerts) != 1 {
+		t.Fatalf("expected 1 alert to be sent, got %d", len(rec.alerts))
+	}
+
+	if err := n.SendDigest(c); err != nil {
+		t.Fatal(err)
+	}
+	if len(rec.alerts) != 2 || rec.alerts[1].Reason != ReasonDigest {
+		t.Fatalf("expected digest alert, got %d", len(rec.alerts))
+	}
+	digest := rec.alerts[1].Digest
+	if len(digest) != 1 || digest[0].Count != 2 || len(digest[0].Examples) != 2 {
+		t.Errorf("unexpected digest %#v", digest)
+	}
+}
@@ -0,0 +1,241 @@
+package locker
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
+)
+
+type (
+	// Timeline is the per-sequence view of a task chain built from the
+	// lock history of an entity
+	Timeline struct {
+		// Key is the string representation of the entity key
+		Key string `json:"key"`
+
+		// Steps are the steps of the chain in sequence order
+		Steps []*Step `json:"steps"`
+	}
+
+	// Step is the summary of a single sequence in a task chain
+	Step struct {
+		// Sequence is the task sequence number
+		Sequence int `json:"sequence"`
+
+		// Scheduled is the time the task for this step was scheduled
+		Scheduled time.Time `json:"scheduled,omitempty"`
+
+		// Start is the time the lock was first acquired for this step
+		Start time.Time `json:"start,omitempty"`
+
+		// End is the time that processing of this step ended
+		End time.Time `json:"end,omitempty"`
+
+		// Duration is the time between Start and End
+		Duration time.Duration `json:"duration_ns"`
+
+		// Attempts is the number of times the lock was acquired
+		Attempts int `json:"attempts"`
+
+		// Retries is the number of failed attempts
+		Retries int `json:"retries"`
+
+		// Overwrites is the number of times an expired lock was overwritten
+		Overwrites int `json:"overwrites"`
+
+		// Duplicates is the number of tasks rejected with ErrTaskExpired
+		Duplicates int `json:"duplicates"`
+
+		// Outcome is the state of the step (see the Outcome* constants)
+		Outcome string `json:"outcome"`
+	}
+)
+
+const (
+	// OutcomePending means the step is scheduled but hasn't started
+	OutcomePending = "pending"
+
+	// OutcomeRunning means the step has started but not ended
+	OutcomeRunning = "running"
+
+	// OutcomeDone means the step ended by scheduling the next step
+	OutcomeDone = "done"
+
+	// OutcomeCompleted means the step ended by completing the chain
+	OutcomeCompleted = "completed"
+
+	// OutcomeFailed means the step failed permanently
+	OutcomeFailed = "failed"
+)
+
+// Timeline returns the timeline of the task chain for an entity
+func (l *Locker) Timeline(c context.Context, key *datastore.Key) (*Timeline, error) {
+	events, err := l.History(c, key)
+	if err != nil {
+		return nil, err
+	}
+	return NewTimeline(key, events), nil
+}
+
+// NewTimeline builds a timeline from lock history events
+func NewTimeline(key *datastore.Key, events []*Event) *Timeline {
+	steps := make(map[int]*Step)
+	step := func(sequence int) *Step {
+		s, ok := steps[sequence]
+		if !ok {
+			s = &Step{Sequence: sequence, Outcome: OutcomePending}
+			steps[sequence] = s
+		}
+		return s
+	}
+	end := func(s *Step, ts time.Time, outcome string) {
+		s.End = ts
+		s.Outcome = outcome
+		if !s.Start.IsZero() {
+			s.Duration = s.End.Sub(s.Start)
+		}
+	}
+
+	// the complete event is recorded after the sequence is reset so
+	// we need to track the last step that was running to attribute it
+	last := -1
+	for _, e := range events {
+		switch e.Type {
+		case EventSchedule, EventFanOut, EventWait, EventCompensate, EventReplay:
+			// scheduling the next sequence ends the current one
+			if prev, ok := steps[e.Sequence-1]; ok && prev.Outcome == OutcomeRunning {
+				end(prev, e.Timestamp, OutcomeDone)
+			}
+			step(e.Sequence).Scheduled = e.Timestamp
+		case EventAcquire, EventRetry:
+			s := step(e.Sequence)
+			if s.Start.IsZero() {
+				s.Start = e.Timestamp
+			}
+			s.Attempts++
+			s.Outcome = OutcomeRunning
+			last = e.Sequence
+		case EventRelease:
+			step(e.Sequence).Retries = e.Retries
+		case EventOverwrite:
+			s := step(e.Sequence)
+			s.Overwrites++
+			s.Attempts++
+		case EventExpire:
+			step(e.Sequence).Duplicates++
+		case EventFail:
+			end(step(e.Sequence), e.Timestamp, OutcomeFailed)
+		case EventComplete:
+			if s, ok := steps[last]; ok {
+				end(s, e.Timestamp, OutcomeCompleted)
+			}
+		}
+	}
+
+	t := &Timeline{
+		Key:   key.String(),
+		Steps: make([]*Step, 0, len(steps)),
+	}
+	for _, s := range steps {
+		t.Steps = append(t.Steps, s)
+	}
+	sort.Slice(t.Steps, func(i, j int) bool {
+		return t.Steps[i].Sequence < t.Steps[j].Sequence
+	})
+	return t
+}
+
+// WriteJSON writes the timeline as JSON
+func (t *Timeline) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(t)
+}
+
+// WriteMermaid writes the timeline as a Mermaid gantt diagram
+func (t *Timeline) WriteMermaid(w io.Writer) error {
+	ew := &errWriter{w: w}
+	ew.printf("gantt\n")
+	ew.printf("    title %s\n", t.Key)
+	ew.printf("    dateFormat x\n")
+	ew.printf("    axisFormat %%H:%%M:%%S\n")
+	for _, s := range t.Steps {
+		if s.Start.IsZero() {
+			continue
+		}
+		finish := s.End
+		if finish.IsZero() {
+			finish = s.Start
+		}
+		ew.printf("    %s :%s s%d, %d, %d\n", s.label(), mermaidTag(s.Outcome), s.Sequence, millis(s.Start), millis(finish))
+	}
+	return ew.err
+}
+
+// WriteDOT writes the timeline as a Graphviz DOT digraph
+func (t *Timeline) WriteDOT(w io.Writer) error {
+	ew := &errWriter{w: w}
+	ew.printf("digraph %q {\n", t.Key)
+	ew.printf("    rankdir=LR;\n")
+	ew.printf("    node [shape=box];\n")
+	for i, s := range t.Steps {
+		ew.printf("    s%d [label=%q, color=%s];\n", s.Sequence, s.label()+"\\n"+s.Duration.String(), dotColor(s.Outcome))
+		if i > 0 {
+			ew.printf("    s%d -> s%d;\n", t.Steps[i-1].Sequence, s.Sequence)
+		}
+	}
+	ew.printf("}\n")
+	return ew.err
+}
+
+// label describes the step for use in diagrams
+func (s *Step) label() string {
+	return fmt.Sprintf("seq %d %s retries %d overwrites %d duplicates %d", s.Sequence, s.Outcome, s.Retries, s.Overwrites, s.Duplicates)
+}
+
+func mermaidTag(outcome string) string {
+	switch outcome {
+	case OutcomeFailed:
+		return "crit,"
+	case OutcomeRunning:
+		return "active,"
+	case OutcomeDone, OutcomeCompleted:
+		return "done,"
+	}
+	return ""
+}
+
+func dotColor(outcome string) string {
+	switch outcome {
+	case OutcomeFailed:
+		return "red"
+	case OutcomeRunning:
+		return "blue"
+	case OutcomeDone, OutcomeCompleted:
+		return "green"
+	}
+	return "gray"
+}
+
+func millis(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+// errWriter keeps the first write error so that output can be
+// written without checking every call
+type errWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (ew *errWriter) printf(format string, args ...interface{}) {
+	if ew.err != nil {
+		return
+	}
+	_, ew.err = fmt.Fprintf(ew.w, format, args...)
+}
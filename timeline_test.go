@@ -0,0 +1,64 @@
+package locker
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
+)
+
+func TestNewTimeline(t *testing.T) {
+	r, _ := instance.NewRequest("GET", "/", nil)
+	c := appengine.NewContext(r)
+	k := datastore.NewKey(c, "foo", "", 1, nil)
+
+	ts := time.Date(2016, 7, 21, 11, 10, 0, 0, time.UTC)
+	at := func(s int) time.Time { return ts.Add(time.Duration(s) * time.Second) }
+	events := []*Event{
+		{Timestamp: at(0), Type: EventSchedule, Sequence: 1},
+		{Timestamp: at(1), Type: EventAcquire, Sequence: 1},
+		{Timestamp: at(2), Type: EventRelease, Sequence: 1, Retries: 1},
+		{Timestamp: at(3), Type: EventRetry, Sequence: 1, Retries: 1},
+		{Timestamp: at(5), Type: EventSchedule, Sequence: 2},
+		{Timestamp: at(6), Type: EventExpire, Sequence: 1, Retries: 0},
+		{Timestamp: at(7), Type: EventAcquire, Sequence: 2},
+		{Timestamp: at(9), Type: EventComplete, Sequence: -1},
+	}
+
+	tl := NewTimeline(k, events)
+	if len(tl.Steps) != 2 {
+		t.Fatalf("expected 2 steps, got %d", len(tl.Steps))
+	}
+
+	s1 := tl.Steps[0]
+	if s1.Outcome != OutcomeDone || s1.Attempts != 2 || s1.Retries != 1 || s1.Duplicates != 1 {
+		t.Errorf("unexpected step 1 %#v", s1)
+	}
+	if s1.Duration != 4*time.Second {
+		t.Errorf("expected step 1 duration 4s, got %s", s1.Duration)
+	}
+
+	s2 := tl.Steps[1]
+	if s2.Outcome != OutcomeCompleted || s2.Duration != 2*time.Second {
+		t.Errorf("unexpected step 2 %#v", s2)
+	}
+
+	buf := new(bytes.Buffer)
+	if err := tl.WriteMermaid(buf); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(buf.String(), "gantt\n") || !strings.Contains(buf.String(), ":done, s2,") {
+		t.Errorf("unexpected mermaid output %s", buf.String())
+	}
+
+	buf.Reset()
+	if err := tl.WriteDOT(buf); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "s1 -> s2;") {
+		t.Errorf("unexpected dot output %s", buf.String())
+	}
+}
@@ -0,0 +1,145 @@
+package locker
+
+import (
+	"net/http"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
+	"google.golang.org/appengine/taskqueue"
+)
+
+type (
+	// ScheduleOption is the signature for per-task scheduling options
+	ScheduleOption func(*taskqueue.Task)
+
+	// sleepUntil is the TaskHandler result that continues the chain with
+	// the next sequence at a later time
+	sleepUntil struct {
+		until time.Time
+	}
+)
+
+// Delay sets the minimum time to wait before the task executes
+func Delay(delay time.Duration) ScheduleOption {
+	return func(task *taskqueue.Task) {
+		task.Delay = delay
+	}
+}
+
+// ETA sets the earliest time the task can execute. The task queue allows
+// tasks to be scheduled up to 30 days ahead.
+func ETA(eta time.Time) ScheduleOption {
+	return func(task *taskqueue.Task) {
+		task.ETA = eta
+	}
+}
+
+// taskWake returns the time a task is due to run or the zero time if it
+// isn't delayed
+func taskWake(task *taskqueue.Task) time.Time {
+	if !task.ETA.IsZero() {
+		return task.ETA
+	}
+	if task.Delay > 0 {
+		return getTime().Add(task.Delay)
+	}
+	return time.Time{}
+}
+
+// Sleep is returned by a TaskHandler to release the lock and continue
+// with the next sequence of the chain, handled by the same url path, at
+// the time given. The lock isn't held while the chain is sleeping.
+func Sleep(until time.Time) error {
+	return &sleepUntil{until}
+}
+
+func (e *sleepUntil) Error() string {
+	return "sleeping until " + e.until.Format(time.RFC3339)
+}
+
+func (e *sleepUntil) suspend(c context.Context, l *Locker, r *http.Request, key *datastore.Key, entity Lockable) error {
+	return l.Schedule(c, key, entity, r.URL.Path, nil, ETA(e.until))
+}
+
+// Reap re-schedules the task for entities of a kind that are waiting on a
+// timer that should have fired more than grace ago, e.g. because the task
+// was lost. The task is re-scheduled with any params stored on the lock
+// (see PersistParams). Chains waiting for a signal past the SignalTimeout
+// are continued or failed. It returns the number of timers that were
+// re-armed or timed out.
+func (l *Locker) Reap(c context.Context, kind string, grace time.Duration) (int, error) {
+	cutoff := getTime().Add(-grace)
+	q := datastore.NewQuery(kind).Filter("lock_wake >", time.Time{}).Filter("lock_wake <", cutoff).KeysOnly()
+	keys, err := q.GetAll(c, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, key := range keys {
+		entity := new(RawEntity)
+		rearmed, timedOut := false, false
+		err := datastore.RunInTransaction(c, func(tc context.Context) error {
+			rearmed, timedOut = false, false
+			if err := datastore.Get(tc, key, entity); err != nil {
+				return err
+			}
+			// the task may have run since the query or the chain been stopped
+			if entity.Status != StatusWaiting || entity.Wake.IsZero() || entity.Wake.After(cutoff) || entity.Control != "" {
+				return nil
+			}
+			if entity.Signal != "" {
+				timedOut = true
+				entity.Wake = time.Time{}
+				if l.FailOnSignalTimeout {
+					// failed in the same transaction as the check so that
+					// a signal delivered since the query isn't lost
+					return l.failLock(tc, nil, key, entity, ErrSignalTimeout)
+				}
+				// continue the chain without a payload
+				entity.Signal = ""
+				entity.Payload = nil
+			} else {
+				rearmed = true
+			}
+			return l.resume(tc, key, entity)
+		}, nil)
+		if err != nil {
+			return count, err
+		}
+		switch {
+		case timedOut:
+			l.log().Info(c, "signal timed out", lockFields(c, key, entity.Sequence, entity.Retries)...)
+			if l.FailOnSignalTimeout {
+				l.failed(c, key, entity, ErrSignalTimeout)
+			}
+			count++
+		case rearmed:
+			l.log().Info(c, "timer re-armed", lockFields(c, key, entity.Sequence, entity.Retries)...)
+			count++
+		}
+	}
+	return count, nil
+}
+
+// ReapHandler returns an http.Handler that reaps lost timers for each of
+// the kinds, intended to be called by cron:
+//
+//	cron:
+//	- description: locker timer reaper
+//	  url: /_locker/reap
+//	  schedule: every 15 minutes
+func (l *Locker) ReapHandler(grace time.Duration, kinds ...string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c := appengine.NewContext(r)
+		for _, kind := range kinds {
+			if _, err := l.Reap(c, kind, grace); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+}
@@ -0,0 +1,72 @@
+package locker
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
+	"google.golang.org/appengine/taskqueue"
+)
+
+func TestTaskWake(t *testing.T) {
+	n := time.Date(2016, 7, 21, 11, 15, 0, 0, time.UTC)
+	getTime = func() time.Time {
+		return n
+	}
+	defer func() { getTime = getTimeDefault }()
+
+	task := new(taskqueue.Task)
+	if wake := taskWake(task); !wake.IsZero() {
+		t.Errorf("expected no wake time, got %s", wake)
+	}
+	Delay(time.Hour)(task)
+	if wake := taskWake(task); !wake.Equal(n.Add(time.Hour)) {
+		t.Errorf("expected wake after delay, got %s", wake)
+	}
+	ETA(n.Add(time.Minute))(task)
+	if wake := taskWake(task); !wake.Equal(n.Add(time.Minute)) {
+		t.Errorf("expected wake at eta, got %s", wake)
+	}
+}
+
+func TestSleepAndReap(t *testing.T) {
+	r, _ := instance.NewRequest("GET", "/", nil)
+	c := appengine.NewContext(r)
+	k := scheduleFoo(t, c, 35, 1)
+
+	l, _ := NewLocker()
+	until := time.Now().Add(time.Hour).Truncate(time.Microsecond)
+	h := l.Handle(func(c context.Context, r *http.Request, key *datastore.Key, entity Lockable) error {
+		return Sleep(until)
+	}, fooFactory)
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, newTaskRequest(t, k, 1))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected chain to sleep, got %d", rec.Code)
+	}
+	lock, _ := l.Inspect(c, k)
+	if lock.Status != StatusWaiting || lock.Sequence != 2 || lock.RequestID != "" || !lock.Wake.Equal(until) {
+		t.Fatalf("expected chain to wait for timer %#v", lock)
+	}
+
+	// the timer isn't due yet
+	if n, err := l.Reap(c, "foo", time.Minute); err != nil || n != 0 {
+		t.Errorf("expected nothing to reap, got %d %v", n, err)
+	}
+
+	getTime = func() time.Time {
+		return until.Add(time.Hour)
+	}
+	defer func() { getTime = getTimeDefault }()
+	if n, err := l.Reap(c, "foo", time.Minute); err != nil || n != 1 {
+		t.Errorf("expected lost timer to be re-armed, got %d %v", n, err)
+	}
+	if lock, _ := l.Inspect(c, k); lock.Status != StatusPending || lock.Sequence != 2 {
+		t.Errorf("expected timer task to be re-scheduled %#v", lock)
+	}
+}
@@ -0,0 +1,131 @@
+package locker
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"strings"
+
+	"golang.org/x/net/context"
+)
+
+type (
+	// TraceHook creates spans for lock operations and propagates the trace
+	// context between the tasks of a chain so that a multi-step chain can
+	// appear as a single trace. The default propagates W3C traceparent
+	// headers without recording spans, see the lockerotel package for an
+	// OpenTelemetry implementation.
+	TraceHook interface {
+		// Extract returns a context carrying the trace context from the
+		// headers of an executing task
+		Extract(c context.Context, header http.Header) context.Context
+
+		// Inject adds the trace context from c to the headers of a task
+		// that is being scheduled
+		Inject(c context.Context, header http.Header)
+
+		// Start starts a new span, the returned func ends it and records
+		// the error (if any) of the operation
+		Start(c context.Context, name string) (context.Context, func(error))
+	}
+
+	// w3cTracer is the default TraceHook which only propagates the
+	// W3C trace context https://www.w3.org/TR/trace-context/
+	w3cTracer struct{}
+
+	// spanContext is the parsed W3C trace context
+	spanContext struct {
+		traceID string
+		spanID  string
+		flags   string
+		state   string
+	}
+)
+
+const (
+	traceparentHeader = "traceparent"
+	tracestateHeader  = "tracestate"
+)
+
+// spanKey is the context key for the current span context
+const spanKey key = 1
+
+// Tracing sets the config setting for a locker
+func Tracing(tracer TraceHook) func(*Locker) error {
+	return func(l *Locker) error {
+		l.Tracing = tracer
+		return nil
+	}
+}
+
+// tracing returns the trace hook of the locker or the default if it isn't set,
+// e.g. for a Locker that wasn't created with NewLocker
+func (l *Locker) tracing() TraceHook {
+	if l.Tracing == nil {
+		return w3cTracer{}
+	}
+	return l.Tracing
+}
+
+func (w3cTracer) Extract(c context.Context, header http.Header) context.Context {
+	sc, ok := parseTraceparent(header.Get(traceparentHeader))
+	if !ok {
+		return c
+	}
+	sc.state = header.Get(tracestateHeader)
+	return context.WithValue(c, spanKey, sc)
+}
+
+func (w3cTracer) Inject(c context.Context, header http.Header) {
+	sc, ok := c.Value(spanKey).(*spanContext)
+	if !ok {
+		// start a new trace for the chain
+		sc = &spanContext{traceID: randomHex(16), spanID: randomHex(8), flags: "01"}
+	}
+	header.Set(traceparentHeader, sc.String())
+	if sc.state != "" {
+		header.Set(tracestateHeader, sc.state)
+	}
+}
+
+func (w3cTracer) Start(c context.Context, name string) (context.Context, func(error)) {
+	parent, ok := c.Value(spanKey).(*spanContext)
+	if !ok {
+		return c, func(error) {}
+	}
+	sc := &spanContext{traceID: parent.traceID, spanID: randomHex(8), flags: parent.flags, state: parent.state}
+	return context.WithValue(c, spanKey, sc), func(error) {}
+}
+
+// parseTraceparent parses a version 00 traceparent header value
+func parseTraceparent(value string) (*spanContext, bool) {
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) < 4 || (parts[0] == "00" && len(parts) != 4) {
+		return nil, false
+	}
+	if parts[0] == "ff" || !isHex(parts[0], 2) || !isHex(parts[1], 32) || !isHex(parts[2], 16) || !isHex(parts[3], 2) {
+		return nil, false
+	}
+	if parts[1] == strings.Repeat("0", 32) || parts[2] == strings.Repeat("0", 16) {
+		return nil, false
+	}
+	return &spanContext{traceID: parts[1], spanID: parts[2], flags: parts[3]}, true
+}
+
+func (sc *spanContext) String() string {
+	return "00-" + sc.traceID + "-" + sc.spanID + "-" + sc.flags
+}
+
+func isHex(s string, n int) bool {
+	if len(s) != n || strings.ToLower(s) != s {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
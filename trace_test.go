@@ -0,0 +1,46 @@
+package locker
+
+import (
+	"net/http"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestTraceparentPropagation(t *testing.T) {
+	tracer := w3cTracer{}
+
+	in := http.Header{}
+	in.Set(traceparentHeader, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	c := tracer.Extract(context.Background(), in)
+
+	c, end := tracer.Start(c, "locker.handler")
+	defer end(nil)
+
+	out := http.Header{}
+	tracer.Inject(c, out)
+	sc, ok := parseTraceparent(out.Get(traceparentHeader))
+	if !ok {
+		t.Fatalf("invalid traceparent %q", out.Get(traceparentHeader))
+	}
+	if sc.traceID != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("expected trace id to be propagated, got %s", sc.traceID)
+	}
+	if sc.spanID == "00f067aa0ba902b7" {
+		t.Errorf("expected new span id")
+	}
+}
+
+func TestTraceparentInvalid(t *testing.T) {
+	for _, value := range []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+	} {
+		if _, ok := parseTraceparent(value); ok {
+			t.Errorf("expected %q to be invalid", value)
+		}
+	}
+}
@@ -0,0 +1,315 @@
+package locker
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
+	"google.golang.org/appengine/taskqueue"
+)
+
+type (
+	// Workflow is a task chain made of named steps. The name of the step
+	// being executed is stored on the Lock alongside the Sequence and the
+	// workflow handler dispatches each task to the handler for its step,
+	// advancing to the next step automatically when the handler succeeds.
+	//
+	//     w := locker.NewWorkflow("/task/order")
+	//     w.Step("charge", chargeHandler)
+	//     w.Step("email", emailHandler)
+	//     http.Handle("/task/order", l.HandleWorkflow(w, orderFactory))
+	//
+	Workflow struct {
+		path  string
+		steps []*WorkflowStep
+		index map[string]*WorkflowStep
+	}
+
+	// WorkflowStep is a named step in a Workflow
+	WorkflowStep struct {
+		name       string
+		handler    TaskHandler
+		compensate TaskHandler
+		next       string
+		branches   []string
+		end        bool
+	}
+)
+
+// stepEnd is the step stored on the lock when the last step of a workflow
+// scheduled or suspended the chain itself, the workflow completes when the
+// task for it runs
+const stepEnd = "_end"
+
+// NewWorkflow creates a new workflow handled at the url path
+func NewWorkflow(path string) *Workflow {
+	return &Workflow{
+		path:  path,
+		index: make(map[string]*WorkflowStep),
+	}
+}
+
+// Step adds a named step to the workflow. Unless Next or End is used, the
+// workflow continues with the step added after it and completes after the
+// last step.
+func (w *Workflow) Step(name string, handler TaskHandler) *WorkflowStep {
+	step := &WorkflowStep{name: name, handler: handler}
+	w.steps = append(w.steps, step)
+	if _, ok := w.index[name]; !ok {
+		w.index[name] = step
+	}
+	return step
+}
+
+// Next sets the step to continue with after this step
+func (s *WorkflowStep) Next(name string) *WorkflowStep {
+	s.next = name
+	return s
+}
+
+// Branch declares the steps that the step handler can continue with using
+// Goto, so that they count as reachable when the workflow is validated
+func (s *WorkflowStep) Branch(names ...string) *WorkflowStep {
+	s.branches = append(s.branches, names...)
+	return s
+}
+
+// End makes the workflow complete after this step
+func (s *WorkflowStep) End() *WorkflowStep {
+	s.end = true
+	return s
+}
+
+// Compensate sets the handler that undoes the step. If the workflow fails
+// permanently the compensation handlers of the completed steps are run in
+// reverse order, each as its own locked task. The steps whose compensation
+// succeeded are recorded in the Compensated field of the lock.
+func (s *WorkflowStep) Compensate(handler TaskHandler) *WorkflowStep {
+	s.compensate = handler
+	return s
+}
+
+// Name returns the name of the step
+func (s *WorkflowStep) Name() string {
+	return s.name
+}
+
+// Validate checks that the workflow is well-formed: it has steps, the
+// step names are unique, every transition is to a known step, every step
+// is reachable from the first through Next or Branch and the steps don't
+// loop forever without a Goto.
+func (w *Workflow) Validate() error {
+	if len(w.steps) == 0 {
+		return fmt.Errorf("locker: workflow %s has no steps", w.path)
+	}
+	for _, step := range w.steps {
+		if step.name == "" || step.name == stepEnd {
+			return fmt.Errorf("locker: workflow %s has a step without a valid name", w.path)
+		}
+		if w.index[step.name] != step {
+			return fmt.Errorf("locker: workflow %s has duplicate step %q", w.path, step.name)
+		}
+		if step.handler == nil {
+			return fmt.Errorf("locker: workflow %s step %q has no handler", w.path, step.name)
+		}
+		if step.next != "" && w.index[step.next] == nil {
+			return fmt.Errorf("locker: workflow %s step %q continues with unknown step %q", w.path, step.name, step.next)
+		}
+		for _, name := range step.branches {
+			if w.index[name] == nil {
+				return fmt.Errorf("locker: workflow %s step %q branches to unknown step %q", w.path, step.name, name)
+			}
+		}
+	}
+
+	// the transitions followed from each step without a Goto must reach
+	// the end, each step can only be visited once otherwise it would loop
+	for _, start := range w.steps {
+		visited := make(map[string]bool)
+		for step := start; step != nil; step = w.next(step) {
+			if visited[step.name] {
+				return fmt.Errorf("locker: workflow %s loops at step %q", w.path, step.name)
+			}
+			visited[step.name] = true
+		}
+	}
+
+	// every step must be reachable from the first
+	reached := map[string]bool{w.steps[0].name: true}
+	queue := []*WorkflowStep{w.steps[0]}
+	for len(queue) > 0 {
+		step := queue[0]
+		queue = queue[1:]
+		targets := step.branches
+		if next := w.next(step); next != nil {
+			targets = append([]string{next.name}, targets...)
+		}
+		for _, name := range targets {
+			if !reached[name] {
+				reached[name] = true
+				queue = append(queue, w.index[name])
+			}
+		}
+	}
+	for _, step := range w.steps {
+		if !reached[step.name] {
+			return fmt.Errorf("locker: workflow %s step %q is unreachable", w.path, step.name)
+		}
+	}
+	return nil
+}
+
+// next returns the step that follows step or nil if it's the last
+func (w *Workflow) next(step *WorkflowStep) *WorkflowStep {
+	if step.end {
+		return nil
+	}
+	if step.next != "" {
+		return w.index[step.next]
+	}
+	for i, s := range w.steps {
+		if s == step && i+1 < len(w.steps) {
+			return w.steps[i+1]
+		}
+	}
+	return nil
+}
+
+// Start schedules the first step of the workflow for the entity
+func (l *Locker) Start(c context.Context, key *datastore.Key, entity Lockable, w *Workflow, params url.Values) error {
+	if len(w.steps) == 0 {
+		return w.Validate()
+	}
+	lock := entity.getLock()
+	lock.Step = w.steps[0].name
+	lock.Compensate = nil
+	lock.Compensated = nil
+	lock.Compensating = false
+	return l.Schedule(c, key, entity, w.path, params)
+}
+
+// Goto schedules a specific step of the workflow for the entity. A step
+// handler can use it to branch instead of continuing with the next step,
+// the step should be declared with Branch.
+func (l *Locker) Goto(c context.Context, key *datastore.Key, entity Lockable, w *Workflow, step string, params url.Values) error {
+	if _, ok := w.index[step]; !ok {
+		return fmt.Errorf("locker: workflow %s has no step %q", w.path, step)
+	}
+	entity.getLock().Step = step
+	return l.Schedule(c, key, entity, w.path, params)
+}
+
+// HandleWorkflow wraps the workflow with task / lock processing, each task
+// is dispatched to the handler of the step stored on the entity lock. It
+// panics if the workflow isn't valid.
+func (l *Locker) HandleWorkflow(w *Workflow, factory EntityFactory, options ...HandlerOption) http.Handler {
+	if err := w.Validate(); err != nil {
+		panic(err)
+	}
+	return l.Handle(l.workflowHandler(w), factory, options...)
+}
+
+// workflowHandler returns the TaskHandler that dispatches to the steps
+func (l *Locker) workflowHandler(w *Workflow) TaskHandler {
+	return func(c context.Context, r *http.Request, key *datastore.Key, entity Lockable) error {
+		lock := entity.getLock()
+		if lock.Step == stepEnd && !lock.Compensating {
+			return l.Complete(c, key, entity)
+		}
+		step, ok := w.index[lock.Step]
+		if !ok {
+			return Permanent(fmt.Errorf("locker: workflow %s has no step %q", w.path, lock.Step))
+		}
+		if lock.Compensating {
+			return l.compensateStep(c, r, key, entity, step)
+		}
+
+		// the step is recorded before it runs so that it's saved if the
+		// handler schedules the next task itself, if the handler fails
+		// the lock is reloaded when it is released
+		if step.compensate != nil {
+			lock.Compensate = append(lock.Compensate, step.name)
+		}
+
+		// the next step is also set before it runs so that a chain that the
+		// handler schedules (without Goto) or suspends continues with it
+		next := w.next(step)
+		if next != nil {
+			lock.Step = next.name
+		} else {
+			lock.Step = stepEnd
+		}
+
+		sequence := lock.Sequence
+		if err := step.handler(c, r, key, entity); err != nil {
+			var susp suspender
+			if !errors.As(err, &susp) {
+				lock.Step = step.name
+			}
+			return err
+		}
+
+		// the handler has scheduled or completed the chain itself
+		if lock.Sequence != sequence {
+			return nil
+		}
+
+		if next == nil {
+			lock.Step = step.name
+			return l.Complete(c, key, entity)
+		}
+		return l.Schedule(c, key, entity, w.path, nil)
+	}
+}
+
+// compensateStep runs the compensation handler for a step of a failed
+// workflow and schedules the compensation of the step before it
+func (l *Locker) compensateStep(c context.Context, r *http.Request, key *datastore.Key, entity Lockable, step *WorkflowStep) error {
+	if step.compensate != nil {
+		if err := step.compensate(c, r, key, entity); err != nil {
+			return err
+		}
+	}
+
+	lock := entity.getLock()
+	lock.Compensated = append(lock.Compensated, step.name)
+	return datastore.RunInTransaction(c, func(tc context.Context) error {
+		if err := keepControl(tc, key, lock); err != nil {
+			return err
+		}
+		if len(lock.Compensate) > 0 {
+			if err := l.compensate(tc, key, lock); err != nil {
+				return err
+			}
+		} else {
+			// all steps have been compensated, the chain remains failed
+			lock.Compensating = false
+			lock.Status = StatusFailed
+		}
+		_, err := datastore.Put(tc, key, entity)
+		return err
+	}, nil)
+}
+
+// compensate schedules the compensation task for the last completed step
+// of a failed workflow, the chain stays failed while it is compensated. It
+// must be called in a transaction and the entity written by the caller.
+func (l *Locker) compensate(c context.Context, key *datastore.Key, lock *Lock) error {
+	last := len(lock.Compensate) - 1
+	step := lock.Compensate[last]
+	lock.Compensate = lock.Compensate[:last]
+	lock.next(lock.Path)
+	lock.Status = StatusFailed
+	lock.Step = step
+	lock.Compensating = true
+
+	task := l.newTask(key, lock.Sequence, lock.Path, nil)
+	l.tracing().Inject(c, task.Header)
+	if _, err := taskqueue.Add(c, task, l.queue(c)); err != nil {
+		return err
+	}
+	return l.record(c, key, EventCompensate, lock.Sequence, lock.Retries)
+}
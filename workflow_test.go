@@ -0,0 +1,214 @@
+package locker
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"golang.org/x/net/context"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
+)
+
+func nopStep(c context.Context, r *http.Request, key *datastore.Key, entity Lockable) error {
+	return nil
+}
+
+func TestWorkflowValidate(t *testing.T) {
+	tests := []struct {
+		name  string
+		build func(w *Workflow)
+		valid bool
+	}{
+		{"linear", func(w *Workflow) {
+			w.Step("charge", nopStep)
+			w.Step("email", nopStep)
+		}, true},
+		{"skip with end", func(w *Workflow) {
+			w.Step("charge", nopStep).Next("email")
+			w.Step("refund", nopStep).End()
+			w.Step("email", nopStep)
+		}, false},
+		{"branch", func(w *Workflow) {
+			w.Step("charge", nopStep).Next("email").Branch("refund")
+			w.Step("refund", nopStep).End()
+			w.Step("email", nopStep)
+		}, true},
+		{"unknown branch", func(w *Workflow) {
+			w.Step("charge", nopStep).Branch("refund")
+		}, false},
+		{"loop after branch", func(w *Workflow) {
+			w.Step("charge", nopStep).End().Branch("poll")
+			w.Step("poll", nopStep).Next("wait")
+			w.Step("wait", nopStep).Next("poll")
+		}, false},
+		{"reserved name", func(w *Workflow) {
+			w.Step(stepEnd, nopStep)
+		}, false},
+		{"empty", func(w *Workflow) {}, false},
+		{"duplicate", func(w *Workflow) {
+			w.Step("charge", nopStep)
+			w.Step("charge", nopStep)
+		}, false},
+		{"unknown next", func(w *Workflow) {
+			w.Step("charge", nopStep).Next("ship")
+		}, false},
+		{"loop", func(w *Workflow) {
+			w.Step("charge", nopStep)
+			w.Step("email", nopStep).Next("charge")
+		}, false},
+		{"no handler", func(w *Workflow) {
+			w.Step("charge", nil)
+		}, false},
+	}
+	for _, test := range tests {
+		w := NewWorkflow("/task/order")
+		test.build(w)
+		if err := w.Validate(); (err == nil) != test.valid {
+			t.Errorf("%s: expected valid %t, got %v", test.name, test.valid, err)
+		}
+	}
+}
+
+func TestWorkflowAdvances(t *testing.T) {
+	r, _ := instance.NewRequest("GET", "/", nil)
+	c := appengine.NewContext(r)
+
+	var ran []string
+	step := func(name string) TaskHandler {
+		return func(c context.Context, r *http.Request, key *datastore.Key, entity Lockable) error {
+			ran = append(ran, name)
+			return nil
+		}
+	}
+	w := NewWorkflow("/task/foo")
+	w.Step("charge", step("charge"))
+	w.Step("email", step("email"))
+
+	l, _ := NewLocker()
+	k := datastore.NewKey(c, "foo", "", 20, nil)
+	if err := l.Start(c, k, &Foo{Value: "test"}, w, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	h := l.HandleWorkflow(w, fooFactory)
+	for seq := 1; seq <= 2; seq++ {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, newTaskRequest(t, k, seq))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("step %d failed with %d", seq, rec.Code)
+		}
+	}
+
+	if len(ran) != 2 || ran[0] != "charge" || ran[1] != "email" {
+		t.Errorf("expected steps to run in order, got %v", ran)
+	}
+	lock, _ := l.Inspect(c, k)
+	if lock.Status != StatusCompleted || lock.Step != "email" {
+		t.Errorf("expected workflow to complete %#v", lock)
+	}
+}
+
+func TestWorkflowCompensation(t *testing.T) {
+	r, _ := instance.NewRequest("GET", "/", nil)
+	c := appengine.NewContext(r)
+
+	var ran []string
+	step := func(name string) TaskHandler {
+		return func(c context.Context, r *http.Request, key *datastore.Key, entity Lockable) error {
+			ran = append(ran, name)
+			return nil
+		}
+	}
+	w := NewWorkflow("/task/foo")
+	w.Step("charge", step("charge")).Compensate(step("refund"))
+	w.Step("reserve", step("reserve")).Compensate(step("release"))
+	w.Step("notify", step("notify"))
+	w.Step("ship", func(c context.Context, r *http.Request, key *datastore.Key, entity Lockable) error {
+		return Permanent(errors.New("out of stock"))
+	})
+
+	l, _ := NewLocker()
+	k := datastore.NewKey(c, "foo", "", 21, nil)
+	if err := l.Start(c, k, &Foo{Value: "test"}, w, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	h := l.HandleWorkflow(w, fooFactory)
+	for seq := 1; seq <= 6; seq++ {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, newTaskRequest(t, k, seq))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("sequence %d failed with %d", seq, rec.Code)
+		}
+		if seq == 4 {
+			// the failure is recorded against the failed step and the
+			// chain stays failed while it is compensated
+			dl, err := l.DeadLetter(c, k)
+			if err != nil || dl.Sequence != 4 {
+				t.Errorf("expected dead letter for sequence 4, got %#v %v", dl, err)
+			}
+			lock, _ := l.Inspect(c, k)
+			if lock.Status != StatusFailed || !lock.Compensating || lock.ErrorSequence != 4 || lock.Sequence != 5 {
+				t.Errorf("expected failed chain to be compensated %#v", lock)
+			}
+		}
+	}
+
+	expected := []string{"charge", "reserve", "notify", "release", "refund"}
+	if len(ran) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, ran)
+	}
+	for i := range expected {
+		if ran[i] != expected[i] {
+			t.Errorf("expected %v, got %v", expected, ran)
+			break
+		}
+	}
+	lock, _ := l.Inspect(c, k)
+	if lock.Status != StatusFailed || lock.Compensating || len(lock.Compensated) != 2 {
+		t.Errorf("expected compensated workflow to be failed %#v", lock)
+	}
+}
+
+func TestWorkflowScheduleAdvances(t *testing.T) {
+	r, _ := instance.NewRequest("GET", "/", nil)
+	c := appengine.NewContext(r)
+
+	var ran []string
+	l, _ := NewLocker()
+	w := NewWorkflow("/task/foo")
+	w.Step("charge", func(c context.Context, r *http.Request, key *datastore.Key, entity Lockable) error {
+		ran = append(ran, "charge")
+		// scheduling without Goto continues with the next step
+		return l.Schedule(c, key, entity, "/task/foo", nil)
+	})
+	w.Step("email", func(c context.Context, r *http.Request, key *datastore.Key, entity Lockable) error {
+		ran = append(ran, "email")
+		// suspending the last step completes the workflow when resumed
+		return Sleep(getTime())
+	})
+
+	k := datastore.NewKey(c, "foo", "", 48, nil)
+	if err := l.Start(c, k, &Foo{Value: "test"}, w, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	h := l.HandleWorkflow(w, fooFactory)
+	for seq := 1; seq <= 3; seq++ {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, newTaskRequest(t, k, seq))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("sequence %d failed with %d", seq, rec.Code)
+		}
+	}
+
+	if len(ran) != 2 || ran[0] != "charge" || ran[1] != "email" {
+		t.Errorf("expected each step to run once, got %v", ran)
+	}
+	lock, _ := l.Inspect(c, k)
+	if lock.Status != StatusCompleted || lock.Sequence != -1 {
+		t.Errorf("expected workflow to complete %#v", lock)
+	}
+}